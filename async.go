@@ -0,0 +1,138 @@
+package fstack
+
+import "sync"
+
+// PushFuture - result of asynchronous push. Resolved when segment is written and synced
+type PushFuture struct {
+	done  chan struct{}
+	seq   uint64
+	depth int
+	err   error
+}
+
+// Done - channel closed when push is finished
+func (pf *PushFuture) Done() <-chan struct{} { return pf.done }
+
+// Wait till push is finished and get sequence number of segment and depth of stack right after
+// the segment was pushed
+func (pf *PushFuture) Wait() (seq uint64, depth int, err error) {
+	<-pf.done
+	return pf.seq, pf.depth, pf.err
+}
+
+func (pf *PushFuture) resolve(seq uint64, depth int, err error) {
+	pf.seq, pf.depth, pf.err = seq, depth, err
+	close(pf.done)
+}
+
+// Queued segment of asynchronous writer
+type asyncRequest struct {
+	message Message
+	future  *PushFuture
+}
+
+// AsyncWriter - pipelined writer of stack. Pushed segments are queued to bounded buffer and
+// background goroutine writes all queued segments by one PushBatch with sync, so callers don't
+// wait for disk individually. Order of segments in stack is order of PushAsync calls
+type AsyncWriter struct {
+	stack  *Stack
+	queue  chan asyncRequest
+	guard  sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewAsyncWriter - create and start asynchronous writer of stack with buffer for bufferSize
+// segments (at least 1). Writer must be closed by Close before stack
+func NewAsyncWriter(stack *Stack, bufferSize int) *AsyncWriter {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	writer := &AsyncWriter{
+		stack: stack,
+		queue: make(chan asyncRequest, bufferSize),
+		done:  make(chan struct{}),
+	}
+	go writer.run()
+	return writer
+}
+
+// PushAsync - queue segment to push. Header and data must not be modified till future is
+// resolved. Blocks if buffer is full (backpressure). After Close future is resolved by ErrClosed.
+// Segment over limits (see WithMaxSize) is not queued and future is resolved by ErrTooLarge
+func (aw *AsyncWriter) PushAsync(header, data []byte) *PushFuture {
+	future := &PushFuture{done: make(chan struct{})}
+	if aw.stack.overLimit(uint64(len(header)), uint64(len(data))) {
+		future.resolve(0, 0, ErrTooLarge)
+		return future
+	}
+	aw.guard.RLock()
+	defer aw.guard.RUnlock()
+	if aw.closed {
+		future.resolve(0, 0, ErrClosed)
+		return future
+	}
+	aw.queue <- asyncRequest{message: Message{Header: header, Data: data}, future: future}
+	return future
+}
+
+// Close - stop accepting segments, write all queued segments and stop background goroutine
+func (aw *AsyncWriter) Close() error {
+	aw.guard.Lock()
+	if !aw.closed {
+		aw.closed = true
+		close(aw.queue)
+	}
+	aw.guard.Unlock()
+	<-aw.done
+	return nil
+}
+
+// Write queued segments: wait for first one and take all others without waiting
+func (aw *AsyncWriter) run() {
+	defer close(aw.done)
+	batch := make([]asyncRequest, 0, cap(aw.queue))
+	messages := make([]Message, 0, cap(aw.queue))
+	for request := range aw.queue {
+		batch = append(batch[:0], request)
+	collect:
+		for len(batch) < cap(batch) {
+			select {
+			case request, ok := <-aw.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, request)
+			default:
+				break collect
+			}
+		}
+		messages = messages[:0]
+		for _, request := range batch {
+			messages = append(messages, request.message)
+		}
+		seq, depth, err := aw.stack.pushDurable(messages)
+		for i, request := range batch {
+			if err != nil {
+				request.future.resolve(0, 0, err)
+			} else {
+				request.future.resolve(seq+uint64(i), depth-len(batch)+i+1, nil)
+			}
+		}
+		for i := range batch {
+			batch[i] = asyncRequest{}
+		}
+	}
+}
+
+// Push batch with sync. Returns sequence number of first segment and depth after push
+func (s *Stack) pushDurable(messages []Message) (firstSeq uint64, depth int, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return 0, 0, err
+	}
+	firstSeq = s.nextSeq
+	err = s.apply(nil, nil, messages, s.pushTimestamp(), firstSeq, true)
+	return firstSeq, s.depth, err
+}
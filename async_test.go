@@ -0,0 +1,108 @@
+package fstack
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestAsyncWriter(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	writer := NewAsyncWriter(stack, 8)
+	const workers, count = 4, 50
+	seqs := make(chan uint64, workers*count)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			futures := make([]*PushFuture, count)
+			for i := range futures {
+				futures[i] = writer.PushAsync([]byte(fmt.Sprint(w)), []byte(fmt.Sprint(i)))
+			}
+			for _, future := range futures {
+				seq, depth, err := future.Wait()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if depth < 1 || uint64(depth) != seq {
+					t.Error("Unexpected depth", depth, "for sequence", seq)
+				}
+				seqs <- seq
+			}
+		}(w)
+	}
+	wg.Wait()
+	writer.Close()
+	close(seqs)
+	unique := make(map[uint64]bool)
+	for seq := range seqs {
+		unique[seq] = true
+	}
+	if len(unique) != workers*count || stack.Depth() != workers*count {
+		t.Fatal("Unexpected count of segments", len(unique), stack.Depth())
+	}
+	if _, _, err = writer.PushAsync([]byte("h"), []byte("d")).Wait(); err != ErrClosed {
+		t.Fatal("Push after close must fail, got", err)
+	}
+}
+
+func TestAsyncWriterOrder(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewAsyncWriter(stack, 2)
+	for i := 0; i < 10; i++ {
+		writer.PushAsync([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i)))
+	}
+	// Close writes all queued segments
+	writer.Close()
+	checkBatchStack(t, stack, 10)
+}
+
+func TestAsyncWriterTooLarge(t *testing.T) {
+	stack, err := NewMemoryStack(WithMaxSize(8, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewAsyncWriter(stack, 8)
+	first := writer.PushAsync([]byte("header"), []byte("body"))
+	large := writer.PushAsync([]byte("header"), make([]byte, 17))
+	last := writer.PushAsync([]byte("header"), []byte("body"))
+	writer.Close()
+	if _, _, err = large.Wait(); err != ErrTooLarge {
+		t.Fatal("Large segment must not be pushed, got", err)
+	}
+	for _, future := range []*PushFuture{first, last} {
+		if _, _, err = future.Wait(); err != nil {
+			t.Fatal("Segment queued with large one must be pushed, got", err)
+		}
+	}
+	if stack.Depth() != 2 {
+		t.Fatal("Unexpected depth", stack.Depth())
+	}
+}
+
+func TestAsyncWriterDepth(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := NewAsyncWriter(stack, 4)
+	// Depth and access time are read while writer changes them (see go test -race)
+	for i := 0; i < 50; i++ {
+		writer.PushAsync([]byte("header"), []byte("body"))
+		stack.Depth()
+		stack.LastAccess()
+	}
+	writer.Close()
+	if stack.Depth() != 50 {
+		t.Fatal("Unexpected depth", stack.Depth())
+	}
+}
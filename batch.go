@@ -0,0 +1,123 @@
+package fstack
+
+// Message - segment for batch push
+type Message struct {
+	Header []byte
+	Data   []byte
+}
+
+// PushBatch - push many segments by one write (and one sync with WithSync option). Batch is
+// atomic: after crash either all segments of batch are in stack or none of them (unfinished batch
+// is removed by Repare). All segments have same push time and consecutive sequence numbers
+// starting from returned one. Empty batch does nothing and returns 0
+func (s *Stack) PushBatch(messages []Message) (firstSeq uint64, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	firstSeq = s.nextSeq
+	return firstSeq, s.apply(nil, nil, messages, s.pushTimestamp(), firstSeq, s.options.sync)
+}
+
+// Push blocks with same timestamp and consecutive sequence numbers by one write to each storage.
+// All blocks except last are marked as open batch. Storages are synced if sync is true. Guard
+// must be locked
+func (s *Stack) pushBatch(messages []Message, timestamp int64, seq uint64, sync bool) error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	var blob Storage
+	if s.split() {
+		blob, err = s.getBlob()
+		if err != nil {
+			return err
+		}
+	}
+	// Lay out blocks
+	startOffset := s.nextBlockPoint()
+	startDataPoint := s.nextDataPoint()
+	blocks := make([]fileBlock, len(messages))
+	offsets := make([]int64, len(messages))
+	offset, dataPoint, prev := startOffset, startDataPoint, s.currentBlockPos
+	buf := s.writeBuf[:0]
+	var blobBuf []byte
+	var meta [fileBlockDefineSize]byte
+	for i, msg := range messages {
+		block := fileBlock{
+			PrevBlock:   uint64(prev),
+			HeaderPoint: uint64(offset + fileBlockDefineSize),
+			HeaderSize:  uint64(len(msg.Header)),
+			DataSize:    uint64(len(msg.Data)),
+			Timestamp:   timestamp,
+			Sequence:    seq + uint64(i),
+			Batch:       i < len(messages)-1,
+		}
+		block.DataPoint = block.HeaderPoint + block.HeaderSize
+		if blob != nil {
+			block.DataPoint = uint64(dataPoint)
+			dataPoint += int64(len(msg.Data))
+			if len(messages) == 1 {
+				blobBuf = msg.Data
+			} else {
+				blobBuf = append(blobBuf, msg.Data...)
+			}
+		}
+		block.encode(meta[:])
+		buf = append(buf, meta[:]...)
+		buf = append(buf, msg.Header...)
+		if blob == nil {
+			buf = append(buf, msg.Data...)
+		}
+		if s.checksums() {
+			var trailer [blockTrailerSize]byte
+			encodeTrailer(trailer[:], blockChecksum(&block, msg.Header, msg.Data))
+			buf = append(buf, trailer[:]...)
+		}
+		blocks[i] = block
+		offsets[i] = offset
+		prev = offset
+		offset = s.blockEnd(&block)
+	}
+	s.writeBuf = buf
+	err = s.writeBlocks(file, blob, buf, blobBuf, startOffset, startDataPoint, sync)
+	if err != nil {
+		// Remove partially written blocks
+		if truncErr := s.truncateTail(); truncErr != nil {
+			s.logger().Println("Can't remove partially written blocks at", startOffset, ":", truncErr)
+		}
+		return err
+	}
+	for i := range blocks {
+		s.pushed(offsets[i], &blocks[i], messages[i].Header, messages[i].Data)
+	}
+	if last := seq + uint64(len(messages)); last > s.nextSeq {
+		s.nextSeq = last
+	}
+	return nil
+}
+
+// Write laid out blocks to file and bodies to blob (if any) and sync if required
+func (s *Stack) writeBlocks(file, blob Storage, buf, blobBuf []byte, offset, dataPoint int64, sync bool) error {
+	if blob != nil {
+		// Write data before meta-info, so block never refers to non-existent data
+		_, err := blob.WriteAt(blobBuf, dataPoint)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := file.WriteAt(buf, offset)
+	if err != nil || !sync {
+		return err
+	}
+	if blob != nil {
+		if err = blob.Sync(); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
@@ -0,0 +1,116 @@
+package fstack
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testBatch(n int) []Message {
+	messages := make([]Message, n)
+	for i := range messages {
+		messages[i] = Message{Header: []byte(fmt.Sprint("header-", i)), Data: []byte(fmt.Sprint("body-", i))}
+	}
+	return messages
+}
+
+func checkBatchStack(t *testing.T, stack *Stack, n int) {
+	if stack.Depth() != n {
+		t.Fatal("Unexpected depth", stack.Depth(), "!=", n)
+	}
+	for i := n - 1; i >= 0; i-- {
+		header, data, err := stack.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(header) != fmt.Sprint("header-", i) || string(data) != fmt.Sprint("body-", i) {
+			t.Fatal("Unexpected segment", string(header), string(data))
+		}
+	}
+}
+
+func TestStackPushBatch(t *testing.T) {
+	stack, err := CreateStack("temp.stack", WithSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if seq, err := stack.PushBatch(nil); err != nil || seq != 0 {
+		t.Fatal("Empty batch must do nothing", seq, err)
+	}
+	if _, err = stack.Push([]byte("header-0"), []byte("body-0")); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := stack.PushBatch(testBatch(5)[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 2 {
+		t.Fatal("Unexpected first sequence number", seq)
+	}
+	if next, _ := stack.Push([]byte("header-5"), []byte("body-5")); next != 6 {
+		t.Fatal("Unexpected sequence after batch", next)
+	}
+	stack.Close()
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	checkBatchStack(t, stack, 6)
+}
+
+func TestSplitStackPushBatch(t *testing.T) {
+	stack, err := NewSplitStorageStack(NewMemoryStorage(), NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.PushBatch(testBatch(4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 4)
+}
+
+func TestStackUnfinishedBatch(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	// Crash before last block of batch was written
+	if err = storage.Truncate(stack.offsets[len(stack.offsets)-1]); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 2)
+}
+
+func BenchmarkStackPushBatch(b *testing.B) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stack.Close()
+	batch := make([]Message, 100)
+	for i := range batch {
+		batch[i] = Message{Header: []byte("112233"), Data: []byte("AAABBBCCC")}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += len(batch) {
+		if _, err = stack.PushBatch(batch); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package fstack
+
+import "time"
+
+// Get buffer of specified size: reuse buf if capacity is enough, otherwise allocate new one
+func grow(buf []byte, size uint64) []byte {
+	if uint64(cap(buf)) < size {
+		return make([]byte, size)
+	}
+	return buf[:size]
+}
+
+// Read header and data of block to buffers. Sizes of block must be checked by caller (see
+// checkBlock): limits are not applied. Guard must be locked
+func (s *Stack) readBlockInto(block *fileBlock, hbuf, bbuf []byte) (header, data []byte, err error) {
+	file, err := s.getFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = checkSize(block.HeaderSize); err == nil {
+		err = checkSize(block.DataSize)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	header = grow(hbuf, block.HeaderSize)
+	data = grow(bbuf, block.DataSize)
+	if _, err = file.ReadAt(header, int64(block.HeaderPoint)); err != nil {
+		return nil, nil, corruptAt(block.offset(), err)
+	}
+	if _, err = dataFile.ReadAt(data, int64(block.DataPoint)); err != nil {
+		return nil, nil, corruptAt(block.offset(), err)
+	}
+	return header, data, nil
+}
+
+// Read header and data of top block to buffers. Guard must be locked
+func (s *Stack) readTopInto(hbuf, bbuf []byte) (header, data []byte, err error) {
+	if cached, ok := s.cachedTop(); ok {
+		header = append(hbuf[:0], cached.header...)
+		data = append(bbuf[:0], cached.data...)
+		return header, data, nil
+	}
+	if err = s.checkBlock(&s.currentBlock, true); err != nil {
+		return nil, nil, err
+	}
+	return s.readBlockInto(&s.currentBlock, hbuf, bbuf)
+}
+
+// PeakInto - same as Peak but header and data are read to hbuf and bbuf. Buffers are grown
+// (new slices allocated) only if capacity is not enough. Returned slices have length of header
+// and data. Returns ErrEmpty for empty stack
+func (s *Stack) PeakInto(hbuf, bbuf []byte) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	return s.readTopInto(hbuf, bbuf)
+}
+
+// PopInto - same as Pop but header and data are read to hbuf and bbuf (see PeakInto)
+func (s *Stack) PopInto(hbuf, bbuf []byte) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	header, data, err = s.readTopInto(hbuf, bbuf)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = s.removeTop(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, data, nil
+}
+
+// PeakHeaderInto - same as PeakHeader but header is read to hbuf (see PeakInto)
+func (s *Stack) PeakHeaderInto(hbuf []byte) (header []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if s.depth == 0 {
+		return nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	if cached, ok := s.cachedTop(); ok {
+		return append(hbuf[:0], cached.header...), nil
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.checkBlock(&s.currentBlock, false); err != nil {
+		return nil, err
+	}
+	header = grow(hbuf, s.currentBlock.HeaderSize)
+	_, err = file.ReadAt(header, int64(s.currentBlock.HeaderPoint))
+	if err != nil {
+		return nil, corruptAt(s.currentBlockPos, err)
+	}
+	return header, nil
+}
+
+// TopSizes - sizes of header and data of top segment without reading them. Useful to prepare
+// buffers for PeakInto and PopInto. Returns zeros for empty stack
+func (s *Stack) TopSizes() (headerSize, dataSize int) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.depth == 0 {
+		return 0, 0
+	}
+	return int(s.currentBlock.HeaderSize), int(s.currentBlock.DataSize)
+}
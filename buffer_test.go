@@ -0,0 +1,75 @@
+package fstack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStackIntoBuffers(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hbuf, bbuf := make([]byte, 0, 4), make([]byte, 0, 4)
+	header, data, err := stack.PeakInto(hbuf, bbuf)
+	if err != ErrEmpty || len(header) != 0 || len(data) != 0 {
+		t.Fatal("Unexpected result on empty stack", header, data, err)
+	}
+	if _, err = stack.Push([]byte("h1"), []byte("d1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-2"), []byte("data-2")); err != nil {
+		t.Fatal(err)
+	}
+	if hs, ds := stack.TopSizes(); hs != 8 || ds != 6 {
+		t.Fatal("Unexpected sizes", hs, ds)
+	}
+	header, data, err = stack.PeakInto(hbuf, bbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "header-2" || string(data) != "data-2" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	header, err = stack.PeakHeaderInto(header)
+	if err != nil || string(header) != "header-2" {
+		t.Fatal("Unexpected header", string(header), err)
+	}
+	if _, _, err = stack.PopInto(hbuf, bbuf); err != nil {
+		t.Fatal(err)
+	}
+	header, data, err = stack.PopInto(hbuf, bbuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "h1" || string(data) != "d1" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	if &header[0] != &hbuf[:1][0] || &data[0] != &bbuf[:1][0] {
+		t.Fatal("Buffers with enough capacity must be reused")
+	}
+	if stack.Depth() != 0 {
+		t.Fatal("Stack must be empty")
+	}
+	if hs, ds := stack.TopSizes(); hs != 0 || ds != 0 {
+		t.Fatal("Unexpected sizes of empty stack", hs, ds)
+	}
+}
+
+func BenchmarkStackPeakInto(b *testing.B) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("112233"), bytes.Repeat([]byte("A"), 1024)); err != nil {
+		b.Fatal(err)
+	}
+	var hbuf, bbuf []byte
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hbuf, bbuf, err = stack.PeakInto(hbuf, bbuf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
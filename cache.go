@@ -0,0 +1,139 @@
+package fstack
+
+// Cached segment from top of stack
+type cacheEntry struct {
+	offset int64
+	header []byte
+	data   []byte
+}
+
+func (ce *cacheEntry) size() int64 { return int64(len(ce.header) + len(ce.data)) }
+
+// Cache of newest segments bounded by total size of headers and bodies. Populated on push and
+// invalidated on pop, so cached entries are always the top of stack
+type topCache struct {
+	maxBytes int64
+	size     int64
+	entries  []cacheEntry // From oldest to newest
+	hits     uint64
+	misses   uint64
+}
+
+// CacheStats - statistic of cache of top segments (see WithCache)
+type CacheStats struct {
+	Hits    uint64 // Reads served from memory
+	Misses  uint64 // Reads served from storage
+	Count   int    // Segments in cache
+	Size    int64  // Size of headers and bodies in cache
+	MaxSize int64  // Limit of size
+}
+
+// Copy segment to cache and evict oldest entries over limit. Segment larger then limit
+// clears cache
+func (tc *topCache) pushed(offset int64, header, data []byte) {
+	if int64(len(header)+len(data)) > tc.maxBytes {
+		// Gap in cached segments is not allowed: cache must be a top of stack
+		tc.reset()
+		return
+	}
+	entry := cacheEntry{offset: offset}
+	entry.header = append(make([]byte, 0, len(header)), header...)
+	entry.data = append(make([]byte, 0, len(data)), data...)
+	tc.entries = append(tc.entries, entry)
+	tc.size += entry.size()
+	evict := 0
+	for tc.size > tc.maxBytes {
+		tc.size -= tc.entries[evict].size()
+		tc.entries[evict] = cacheEntry{}
+		evict++
+	}
+	if evict > 0 {
+		tc.entries = append(tc.entries[:0], tc.entries[evict:]...)
+	}
+}
+
+// Remove segment from cache. Returns removed entry if it was cached
+func (tc *topCache) popped(offset int64) (cacheEntry, bool) {
+	top, ok := tc.top(offset)
+	if !ok {
+		// Cache can't be out of order - drop it
+		tc.reset()
+		return top, false
+	}
+	tc.entries[len(tc.entries)-1] = cacheEntry{}
+	tc.entries = tc.entries[:len(tc.entries)-1]
+	tc.size -= top.size()
+	return top, true
+}
+
+// Get cached segment on top of stack at offset
+func (tc *topCache) top(offset int64) (cacheEntry, bool) {
+	if len(tc.entries) == 0 || tc.entries[len(tc.entries)-1].offset != offset {
+		return cacheEntry{}, false
+	}
+	return tc.entries[len(tc.entries)-1], true
+}
+
+// Lookup segment on top of stack and count hit or miss
+func (tc *topCache) lookup(offset int64) (cacheEntry, bool) {
+	entry, ok := tc.top(offset)
+	if ok {
+		tc.hits++
+	} else {
+		tc.misses++
+	}
+	return entry, ok
+}
+
+// Remove all entries but keep counters
+func (tc *topCache) reset() {
+	tc.entries = nil
+	tc.size = 0
+}
+
+// Notify cache about pushed segment. Guard must be locked
+func (s *Stack) cachePushed(offset int64, header, data []byte) {
+	if s.cache != nil {
+		s.cache.pushed(offset, header, data)
+	}
+}
+
+// Notify cache about popped segment and get it from cache if possible. Guard must be locked
+func (s *Stack) cachePopped(offset int64) (cacheEntry, bool) {
+	if s.cache == nil {
+		return cacheEntry{}, false
+	}
+	return s.cache.popped(offset)
+}
+
+// Get top segment from cache. Guard must be locked
+func (s *Stack) cachedTop() (cacheEntry, bool) {
+	if s.cache == nil {
+		return cacheEntry{}, false
+	}
+	return s.cache.lookup(s.currentBlockPos)
+}
+
+// Drop cached segments. Guard must be locked
+func (s *Stack) cacheReset() {
+	if s.cache != nil {
+		s.cache.reset()
+	}
+}
+
+// CacheStats - get statistic of cache of top segments. Returns zero stats if cache is not enabled
+// by WithCache
+func (s *Stack) CacheStats() CacheStats {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:    s.cache.hits,
+		Misses:  s.cache.misses,
+		Count:   len(s.cache.entries),
+		Size:    s.cache.size,
+		MaxSize: s.cache.maxBytes,
+	}
+}
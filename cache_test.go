@@ -0,0 +1,82 @@
+package fstack
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStackCache(t *testing.T) {
+	stack, err := NewMemoryStack(WithCache(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	for i := 0; i < 5; i++ {
+		// 10 bytes per segment: only 2 newest are cached
+		if _, err = stack.Push([]byte(fmt.Sprint("head", i)), []byte(fmt.Sprint("body", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := stack.CacheStats(); stats.Count != 2 || stats.Size != 20 || stats.MaxSize != 20 {
+		t.Fatal("Unexpected cache state", stats)
+	}
+	for i := 4; i >= 0; i-- {
+		header, err := stack.PeakHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		header[0] = 'X' // Cached header must be not changed
+		header, data, err := stack.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(header) != fmt.Sprint("head", i) || string(data) != fmt.Sprint("body", i) {
+			t.Fatal("Unexpected segment", string(header), string(data))
+		}
+	}
+	if stats := stack.CacheStats(); stats.Hits != 4 || stats.Misses != 6 || stats.Count != 0 || stats.Size != 0 {
+		t.Fatal("Unexpected cache stats", stats)
+	}
+}
+
+func TestStackCacheTooLarge(t *testing.T) {
+	stack, err := NewMemoryStack(WithCache(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("h"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("large data")); err != nil {
+		t.Fatal(err)
+	}
+	if stats := stack.CacheStats(); stats.Count != 0 {
+		t.Fatal("Large segment must not be cached", stats)
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	header, data, err := stack.Peak()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "h" || string(data) != "d" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+}
+
+func TestStackNoCache(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("h"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Peak(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := stack.CacheStats(); stats != (CacheStats{}) {
+		t.Fatal("Unexpected stats without cache", stats)
+	}
+}
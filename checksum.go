@@ -0,0 +1,180 @@
+package fstack
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Crash consistency model.
+//
+// Stack is consistent if it's a valid prefix of pushed segments: every change either is fully
+// visible after crash and Repare or not visible at all. It's achieved by order of writes:
+//
+//   - Push (and PushBatch) appends blocks by one write. In split layout bodies are written to
+//     blob before meta-info. Each block ends by commit marker (trailer): CRC32C of meta-info,
+//     header and data plus magic. Block without valid trailer is not committed. On write error
+//     written garbage is truncated.
+//   - Blocks of batch except last are marked as open batch. Batch without last block is dropped.
+//   - Pop (and PopN) writes high-water mark of sequence numbers to super block, closes partially
+//     popped batch and only then truncates file (and blob). Truncate is atomic for file system.
+//   - Operations which overwrite existent blocks (Tx with pops and pushes, ReplaceTop,
+//     UpdateTopHeader) save overwritten regions to undo journal before change. Journal is
+//     applied back on open, so interrupted operation is rolled back.
+//   - Repare (on open) drops everything after first block with broken structure or without commit
+//     marker, verifies checksums of the last committed batch (only tail can be torn by crash),
+//     drops unfinished batch and orphan bodies in blob.
+//
+// Without WithSync durability after power loss depends on OS: consistent prefix is guaranteed for
+// process crash, not for lost pages of file system cache.
+const blockTrailerSize = 4 + 4
+
+var (
+	blockTrailerMagic = [4]byte{'F', 'S', 'B', 'E'}
+	castagnoli        = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Checksum of block: meta-info (without batch flag), header and data
+func blockChecksum(block *fileBlock, header, data []byte) uint32 {
+	var meta [fileBlockDefineSize]byte
+	sealed := *block
+	sealed.Batch = false
+	sealed.encode(meta[:])
+	crc := crc32.Checksum(meta[:], castagnoli)
+	crc = crc32.Update(crc, castagnoli, header)
+	return crc32.Update(crc, castagnoli, data)
+}
+
+// Checksum of block after meta-info and header are changed and data is kept: CRC is linear, so
+// difference of checksums of old and new prefix (meta-info and header) is shifted over data
+// (as zlib crc32_combine does) without reading data
+func updateChecksum(crc, oldPrefix, newPrefix uint32, dataSize uint64) uint32 {
+	return crc ^ shiftChecksum(oldPrefix^newPrefix, dataSize)
+}
+
+// Apply size zero bytes to raw CRC32C register by GF(2) matrices of zero operator
+func shiftChecksum(crc uint32, size uint64) uint32 {
+	var even, odd [32]uint32
+	odd[0] = 0x82f63b78 // Reversed Castagnoli polynomial: operator for one zero bit
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2Square(&even, &odd) // Two zero bits
+	gf2Square(&odd, &even) // Four zero bits
+	for size > 0 {
+		gf2Square(&even, &odd)
+		if size&1 != 0 {
+			crc = gf2Times(&even, crc)
+		}
+		size >>= 1
+		if size == 0 {
+			break
+		}
+		gf2Square(&odd, &even)
+		if size&1 != 0 {
+			crc = gf2Times(&odd, crc)
+		}
+		size >>= 1
+	}
+	return crc
+}
+
+func gf2Times(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2Square(square, mat *[32]uint32) {
+	for n := range mat {
+		square[n] = gf2Times(mat, mat[n])
+	}
+}
+
+// Encode commit marker of block to buffer
+func encodeTrailer(buf []byte, crc uint32) {
+	binary.LittleEndian.PutUint32(buf, crc)
+	copy(buf[4:], blockTrailerMagic[:])
+}
+
+// Has stack commit markers after blocks
+func (s *Stack) checksums() bool { return s.super.Flags&flagChecksum != 0 }
+
+// Size of commit marker of block or 0 if stack has no markers
+func (s *Stack) trailerSize() int64 {
+	if s.checksums() {
+		return blockTrailerSize
+	}
+	return 0
+}
+
+// Read commit marker of block. Returns false if marker has no magic. Guard must be locked
+func (s *Stack) readTrailer(file io.ReaderAt, block *fileBlock) (crc uint32, ok bool, err error) {
+	var buf [blockTrailerSize]byte
+	_, err = file.ReadAt(buf[:], s.blockEnd(block)-blockTrailerSize)
+	if err == io.EOF {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if [4]byte{buf[4], buf[5], buf[6], buf[7]} != blockTrailerMagic {
+		return 0, false, nil
+	}
+	return binary.LittleEndian.Uint32(buf[:]), true, nil
+}
+
+// Check commit marker and checksum of block content. Guard must be locked
+func (s *Stack) verifyBlock(block *fileBlock) (bool, error) {
+	if !s.checksums() {
+		return true, nil
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return false, err
+	}
+	crc, ok, err := s.readTrailer(file, block)
+	if err != nil || !ok {
+		return false, err
+	}
+	// Limits of sizes are not applied: block must not be dropped for them
+	header, data, err := s.readBlockInto(block, nil, nil)
+	if errors.Is(err, ErrCorrupt) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return blockChecksum(block, header, data) == crc, nil
+}
+
+// Write commit marker of block with checksum of stored content. Guard must be locked
+func (s *Stack) sealBlock(block *fileBlock) error {
+	if !s.checksums() {
+		return nil
+	}
+	header, data, err := s.readBlockInto(block, nil, nil)
+	if err != nil {
+		return err
+	}
+	return s.writeTrailer(block, blockChecksum(block, header, data))
+}
+
+// Write commit marker of block. Guard must be locked
+func (s *Stack) writeTrailer(block *fileBlock, crc uint32) error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	var buf [blockTrailerSize]byte
+	encodeTrailer(buf[:], crc)
+	_, err = file.WriteAt(buf[:], s.blockEnd(block)-blockTrailerSize)
+	return err
+}
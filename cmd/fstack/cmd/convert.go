@@ -0,0 +1,53 @@
+// Copyright © 2016 RedDec <net.dev@mail.ru>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"github.com/reddec/file-stack"
+	"github.com/spf13/cobra"
+)
+
+var outputFile, outputBlobFile string
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert stack layout",
+	Long: `Copy all messages to new stack keeping push time and sequence numbers.
+If output blob file is set, new stack will be in split layout, otherwise in interleaved`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var dst *fstack.Stack
+		var err error
+		if outputBlobFile != "" {
+			dst, err = fstack.CreateSplitStack(outputFile, outputBlobFile)
+		} else {
+			dst, err = fstack.CreateStack(outputFile)
+		}
+		if err != nil {
+			fail(err)
+		}
+		defer dst.Close()
+		err = fstack.Convert(dst, stack)
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(convertCmd)
+	convertCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "converted.stack", "output stack file name")
+	convertCmd.PersistentFlags().StringVar(&outputBlobFile, "output-blob", "", "output bodies file name (for split layout)")
+}
@@ -0,0 +1,102 @@
+// Copyright © 2016 RedDec <net.dev@mail.ru>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/reddec/file-stack"
+	"github.com/spf13/cobra"
+)
+
+// parseFilter converts expression to filter. Supported expressions:
+// key=value, key!=value, key^=prefix, key>N, key>=N, key<N, key<=N, key (exists), !key (not exists)
+func parseFilter(expr string) (fstack.Filter, error) {
+	if strings.HasPrefix(expr, "!") && !strings.ContainsAny(expr, "=<>") {
+		return fstack.Not(fstack.Exists(expr[1:])), nil
+	}
+	for _, op := range []string{"!=", "^=", ">=", "<=", "=", ">", "<"} {
+		idx := strings.Index(expr, op)
+		if idx == -1 {
+			continue
+		}
+		key, value := expr[:idx], expr[idx+len(op):]
+		switch op {
+		case "=":
+			return fstack.Eq(key, value), nil
+		case "!=":
+			return fstack.Not(fstack.Eq(key, value)), nil
+		case "^=":
+			return fstack.Prefix(key, value), nil
+		}
+		num, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ">":
+			return fstack.Gt(key, num), nil
+		case ">=":
+			return fstack.Ge(key, num), nil
+		case "<":
+			return fstack.Lt(key, num), nil
+		default:
+			return fstack.Le(key, num), nil
+		}
+	}
+	return fstack.Exists(expr), nil
+}
+
+var findCount uint64
+
+// findCmd represents the find command
+var findCmd = &cobra.Command{
+	Use:   "find [expression...]",
+	Short: "Messages matched by headers",
+	Long: `Get but not remove messages which headers matched by all expressions (from first to last).
+Expressions: key=value, key!=value, key^=prefix, key>N, key>=N, key<N, key<=N, key (exists), !key (not exists).
+Equality on field from --index uses secondary index. Headers to Stderr, body to Stdout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var filters []fstack.Filter
+		for _, expr := range args {
+			filter, err := parseFilter(expr)
+			if err != nil {
+				fail(err)
+			}
+			filters = append(filters, filter)
+		}
+		var n uint64
+		err := stack.IterateFiltered(fstack.And(filters...), func(depth int, header []byte, body io.Reader) bool {
+			bdata, err := ioutil.ReadAll(body)
+			if err != nil {
+				fail(err)
+			}
+			showMessage(header, bdata, n > 0)
+			n++
+			return findCount == 0 || n < findCount
+		})
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(findCmd)
+	findCmd.PersistentFlags().Uint64VarP(&findCount, "count", "n", 0, "maximum messages count (0 - unlimited)")
+}
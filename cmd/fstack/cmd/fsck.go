@@ -0,0 +1,74 @@
+// Copyright © 2016 RedDec <net.dev@mail.ru>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/reddec/file-stack"
+	"github.com/spf13/cobra"
+)
+
+var fsckRepair, fsckChecksums, fsckHeaders bool
+
+// fsckCmd represents the fsck command
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Verify stack",
+	Long: `Check integrity of stack without any changes and print JSON report to Stdout.
+Exit code is 1 if problems are found. With --repair stack is repaired after check:
+broken tail is removed and blocks after first block with wrong checksum are dropped`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := fstack.VerifyOptions{Checksums: fsckChecksums, Headers: fsckHeaders}
+		var err error
+		stack, err = openStack(fstack.WithReadOnly())
+		if err != nil {
+			fail(err)
+		}
+		report, err := stack.Verify(opts)
+		if err != nil {
+			fail(err)
+		}
+		if fsckRepair && !report.OK() && !readOnly {
+			stack.Close()
+			// Open repairs tail of stack
+			stack, err = openStack()
+			if err != nil {
+				fail(err)
+			}
+			report.Repairs = append(report.Repairs, stack.LastRepair())
+			opts.Repair = true
+			repaired, err := stack.Verify(opts)
+			if err != nil {
+				fail(err)
+			}
+			report.Repairs = append(report.Repairs, repaired.Repairs...)
+		}
+		data, _ := json.MarshalIndent(report, "", "    ")
+		os.Stdout.Write(append(data, '\n'))
+		if !report.OK() {
+			stack.Close()
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&fsckRepair, "repair", false, "repair stack after check")
+	fsckCmd.Flags().BoolVar(&fsckChecksums, "checksums", true, "verify checksums of all blocks")
+	fsckCmd.Flags().BoolVar(&fsckHeaders, "headers", false, "check that headers are JSON objects (non-JSON headers are valid for library)")
+}
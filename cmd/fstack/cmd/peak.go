@@ -14,12 +14,7 @@
 
 package cmd
 
-import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
-)
+import "github.com/spf13/cobra"
 
 // peakCmd represents the peak command
 var peakCmd = &cobra.Command{
@@ -27,14 +22,10 @@ var peakCmd = &cobra.Command{
 	Short: "PEAK opertation for stack",
 	Long:  `Get last message from top of stack, print it but NOT remove. Headers are printed to Stderr, body to Stdout`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if stack.Depth() == 0 {
-			fmt.Fprintln(os.Stderr, "stack is empty")
-			os.Exit(1)
-		}
 		sep = normalizeSeparator(sep)
 		headers, body, err := stack.Peak()
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 		showMessage(headers, body, false)
 	},
@@ -14,12 +14,7 @@
 
 package cmd
 
-import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
-)
+import "github.com/spf13/cobra"
 
 func normalizeSeparator(sep string) string {
 	switch sep {
@@ -39,13 +34,9 @@ var popCmd = &cobra.Command{
 	Short: "POP opertation for stack",
 	Long:  `Get last message from top of stack, print it and remove. Headers are printed to Stderr, body to Stdout`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if stack.Depth() == 0 {
-			fmt.Fprintln(os.Stderr, "stack is empty")
-			os.Exit(1)
-		}
 		headers, body, err := stack.Pop()
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 		showMessage(headers, body, false)
 	},
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -36,18 +37,18 @@ var pushCmd = &cobra.Command{
 		for _, head := range headers {
 			parts := strings.SplitN(head, "=", 2)
 			if len(parts) != 2 {
-				panic("BAD header: must be key=value")
+				fail(errors.New("BAD header: must be key=value"))
 			}
 			heads[parts[0]] = parts[1]
 		}
 		body, err := ioutil.ReadAll(os.Stdin)
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 		data, _ := json.Marshal(heads)
 		id, err := stack.Push(data, body)
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 		fmt.Println(id)
 	},
@@ -0,0 +1,73 @@
+// Copyright © 2016 RedDec <net.dev@mail.ru>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var since, until string
+
+// rangeCmd represents the range command
+var rangeCmd = &cobra.Command{
+	Use:   "range",
+	Short: "Messages in time range",
+	Long: `Get but not remove messages pushed in time range [since, until).
+Time is in RFC3339 format. Headers to Stderr, body to Stdout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var from, to time.Time
+		var err error
+		if since != "" {
+			from, err = time.Parse(time.RFC3339Nano, since)
+			if err != nil {
+				fail(err)
+			}
+		}
+		if until != "" {
+			to, err = time.Parse(time.RFC3339Nano, until)
+			if err != nil {
+				fail(err)
+			}
+		}
+		cursor, err := stack.RangeByTime(from, to)
+		if err != nil {
+			fail(err)
+		}
+		var notFirst bool
+		for cursor.Next() {
+			hdata, err := cursor.Header()
+			if err != nil {
+				fail(err)
+			}
+			bdata, err := cursor.Body()
+			if err != nil {
+				fail(err)
+			}
+			showMessage(hdata, bdata, notFirst)
+			notFirst = true
+		}
+		if cursor.Err() != nil {
+			fail(cursor.Err())
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rangeCmd)
+	rangeCmd.PersistentFlags().StringVar(&since, "since", "", "begining of time range (inclusive)")
+	rangeCmd.PersistentFlags().StringVar(&until, "until", "", "end of time range (exclusive)")
+}
@@ -26,6 +26,9 @@ import (
 
 var cfgFile string
 var stackFile string
+var blobFile string
+var indexFields []string
+var readOnly bool
 var stack *fstack.Stack
 var asJSON, asJSONbin bool
 var msgSep string
@@ -36,6 +39,12 @@ var RootCmd = &cobra.Command{
 	Use:   "fstack",
 	Short: "File base stack",
 	Long:  `Command line utility to operate with file based stack`,
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		// Flush indexes to sidecar files
+		if stack != nil {
+			stack.Close()
+		}
+	},
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -47,6 +56,15 @@ func Execute() {
 	}
 }
 
+// Print error and exit. Stack is closed to flush indexes
+func fail(err error) {
+	if stack != nil {
+		stack.Close()
+	}
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func showMessage(headers, body []byte, notFirst bool) {
 	if notFirst {
 		msgSep = normalizeSeparator(msgSep)
@@ -56,7 +74,7 @@ func showMessage(headers, body []byte, notFirst bool) {
 	var h map[string]string
 	err := json.Unmarshal(headers, &h)
 	if err != nil {
-		panic(err)
+		fail(err)
 	}
 	if asJSONbin {
 		msg := struct {
@@ -94,6 +112,9 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.fstack.yaml)")
 	RootCmd.PersistentFlags().StringVarP(&stackFile, "file", "f", "file.stack", "stack file name")
+	RootCmd.PersistentFlags().StringVar(&blobFile, "blob", "", "bodies file name for stack in split layout")
+	RootCmd.PersistentFlags().BoolVar(&readOnly, "read-only", false, "open stack without any modification of files (damage is not repaired)")
+	RootCmd.PersistentFlags().StringSliceVar(&indexFields, "index", []string{}, "header fields with secondary index (kept in sidecar files)")
 
 	RootCmd.PersistentFlags().BoolVarP(&asJSON, "json", "j", false, `output as json with string body`)
 	RootCmd.PersistentFlags().BoolVar(&asJSONbin, "json-bin", false, `output as json with base64 body (replaces json)`)
@@ -121,9 +142,38 @@ func initConfig() {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
 
-	fs, err := fstack.OpenStack(stackFile)
+	// fsck and salvage open stack by themselves: usual open repairs stack
+	if command, _, err := RootCmd.Find(os.Args[1:]); err == nil && (command == fsckCmd || command == salvageCmd) {
+		return
+	}
+	fs, err := openStack()
 	if err != nil {
-		panic(err)
+		fail(err)
 	}
 	stack = fs
 }
+
+// Open stack from flags with indexes
+func openStack(options ...fstack.Option) (*fstack.Stack, error) {
+	var fs *fstack.Stack
+	var err error
+	if readOnly {
+		options = append(options, fstack.WithReadOnly())
+	}
+	if blobFile != "" {
+		fs, err = fstack.OpenSplitStack(stackFile, blobFile, options...)
+	} else {
+		fs, err = fstack.OpenStack(stackFile, options...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range indexFields {
+		err = fs.AddIndex(field)
+		if err != nil {
+			fs.Close()
+			return nil, err
+		}
+	}
+	return fs, nil
+}
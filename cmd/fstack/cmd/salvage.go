@@ -0,0 +1,74 @@
+// Copyright © 2016 RedDec <net.dev@mail.ru>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/reddec/file-stack"
+	"github.com/spf13/cobra"
+)
+
+var salvageOutput, salvageOutputBlob, quarantineFile string
+
+// salvageCmd represents the salvage command
+var salvageCmd = &cobra.Command{
+	Use:   "salvage",
+	Short: "Recover damaged stack",
+	Long: `Copy all recoverable messages (including messages after damaged regions) to new stack
+keeping push time and sequence numbers. Source stack is not changed. Damaged regions are written
+to quarantine file. JSON report is printed to Stdout`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		stack, err = openStack(fstack.WithReadOnly())
+		if err != nil {
+			fail(err)
+		}
+		var dst *fstack.Stack
+		if salvageOutputBlob != "" {
+			dst, err = fstack.CreateSplitStack(salvageOutput, salvageOutputBlob)
+		} else {
+			dst, err = fstack.CreateStack(salvageOutput)
+		}
+		if err != nil {
+			fail(err)
+		}
+		defer dst.Close()
+		var quarantine io.Writer
+		if quarantineFile != "" {
+			f, err := os.Create(quarantineFile)
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			quarantine = f
+		}
+		report, err := fstack.Salvage(dst, stack, quarantine)
+		if err != nil {
+			fail(err)
+		}
+		data, _ := json.MarshalIndent(report, "", "    ")
+		os.Stdout.Write(append(data, '\n'))
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(salvageCmd)
+	salvageCmd.Flags().StringVarP(&salvageOutput, "output", "o", "salvaged.stack", "output stack file name")
+	salvageCmd.Flags().StringVar(&salvageOutputBlob, "output-blob", "", "output bodies file name (for split layout)")
+	salvageCmd.Flags().StringVarP(&quarantineFile, "quarantine", "q", "", "file for damaged regions")
+}
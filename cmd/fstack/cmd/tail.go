@@ -34,18 +34,18 @@ var tailCmd = &cobra.Command{
 		err := stack.IterateForward(func(depth int, header io.Reader, body io.Reader) bool {
 			hdata, err := ioutil.ReadAll(header)
 			if err != nil {
-				panic(err)
+				fail(err)
 			}
 			bdata, err := ioutil.ReadAll(body)
 			if err != nil {
-				panic(err)
+				fail(err)
 			}
 			showMessage(hdata, bdata, n > 0)
 			n++
 			return n < count
 		})
 		if err != nil {
-			panic(err)
+			fail(err)
 		}
 	},
 }
@@ -0,0 +1,56 @@
+package fstack
+
+import "time"
+
+// TopSeq - sequence number of segment on top of stack. Returns 0 for empty stack
+func (s *Stack) TopSeq() uint64 {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.depth == 0 {
+		return 0
+	}
+	return s.currentBlock.Sequence
+}
+
+// PopIf - pop segment from top of stack only if it has expected sequence number (see Push and
+// TopSeq), otherwise ErrConflict returned and stack is not changed. Check and pop are atomic, so
+// several consumers can't remove segment examined by another one
+func (s *Stack) PopIf(expectedSeq uint64) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	s.lastAccess = time.Now()
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
+	}
+	if s.currentBlock.Sequence != expectedSeq {
+		return nil, nil, ErrConflict
+	}
+	return s.pop()
+}
+
+// PopIfHeader - pop segment from top of stack only if predicate returns true for its header,
+// otherwise ErrConflict returned and stack is not changed. Stack is locked while predicate runs.
+// ErrEmpty returned for empty stack
+func (s *Stack) PopIfHeader(predicate func(header []byte) bool) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	s.lastAccess = time.Now()
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
+	}
+	if cached, ok := s.cachedTop(); ok {
+		header = cached.header
+	} else if header, err = s.readHeaderAt(s.currentBlockPos); err != nil {
+		return nil, nil, err
+	}
+	if !predicate(header) {
+		return nil, nil, ErrConflict
+	}
+	return s.pop()
+}
@@ -0,0 +1,62 @@
+package fstack
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStackPopIf(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.PopIf(0); err != ErrEmpty {
+		t.Fatal("ErrEmpty expected for empty stack, got", err)
+	}
+	first, _ := stack.Push([]byte("header-0"), []byte("body-0"))
+	seq := stack.TopSeq()
+	if seq != first {
+		t.Fatal("Unexpected top sequence", seq, "!=", first)
+	}
+	// Another consumer pushed segment after examining
+	if _, err = stack.Push([]byte("header-1"), []byte("body-1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.PopIf(seq); err != ErrConflict {
+		t.Fatal("Conflict expected, got", err)
+	}
+	if stack.Depth() != 2 {
+		t.Fatal("Stack must not be changed on conflict")
+	}
+	header, data, err := stack.PopIf(stack.TopSeq())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "header-1" || string(data) != "body-1" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+}
+
+func TestStackPopIfHeader(t *testing.T) {
+	stack, err := NewMemoryStack(WithCache(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	isFirst := func(header []byte) bool { return bytes.Equal(header, []byte("header-0")) }
+	if _, _, err = stack.PopIfHeader(isFirst); err != ErrConflict {
+		t.Fatal("Conflict expected, got", err)
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := stack.PopIfHeader(isFirst)
+	if err != nil || string(header) != "header-0" {
+		t.Fatal("Unexpected result", string(header), err)
+	}
+	if _, _, err = stack.PopIfHeader(isFirst); err != ErrEmpty {
+		t.Fatal("ErrEmpty expected for empty stack, got", err)
+	}
+}
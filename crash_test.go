@@ -0,0 +1,295 @@
+package fstack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// Kinds of simulated faults
+const (
+	faultShort = iota // Only first half of write is persisted
+	faultTorn         // Only second half of write is persisted
+	faultHole         // Middle of write is not persisted
+	faultKinds
+)
+
+// Fault plan shared by all storages of stack: operation number failAt fails by fault of kind and
+// all next operations fail without effect (process is dead)
+type faultPlan struct {
+	ops     int
+	failAt  int
+	kind    int
+	crashed bool
+}
+
+// Check next operation: returns true if operation must be applied fully
+func (fp *faultPlan) next() bool {
+	if fp.crashed {
+		return false
+	}
+	fp.ops++
+	if fp.ops-1 == fp.failAt {
+		fp.crashed = true
+		return false
+	}
+	return true
+}
+
+// Memory storage with fault injection for write operations
+type faultStorage struct {
+	*MemoryStorage
+	plan *faultPlan
+}
+
+func (fs *faultStorage) WriteAt(p []byte, offset int64) (int, error) {
+	if fs.plan.crashed {
+		return 0, errCrash
+	}
+	if fs.plan.next() {
+		return fs.MemoryStorage.WriteAt(p, offset)
+	}
+	half, third := len(p)/2, len(p)/3
+	switch fs.plan.kind {
+	case faultShort:
+		fs.MemoryStorage.WriteAt(p[:half], offset)
+	case faultTorn:
+		fs.MemoryStorage.WriteAt(p[half:], offset+int64(half))
+	case faultHole:
+		fs.MemoryStorage.WriteAt(p[:third], offset)
+		fs.MemoryStorage.WriteAt(p[len(p)-third:], offset+int64(len(p)-third))
+	}
+	return 0, errCrash
+}
+
+func (fs *faultStorage) Truncate(size int64) error {
+	if !fs.plan.next() {
+		return errCrash
+	}
+	return fs.MemoryStorage.Truncate(size)
+}
+
+func (fs *faultStorage) Sync() error {
+	if !fs.plan.next() {
+		return errCrash
+	}
+	return nil
+}
+
+// Scenario of operations over stack which covers all write paths
+var crashScenario = []func(s *Stack) error{
+	func(s *Stack) error { _, err := s.Push([]byte("a"), []byte("body-a")); return err },
+	func(s *Stack) error {
+		_, err := s.PushBatch([]Message{{[]byte("b"), []byte("body-b")}, {[]byte("c"), []byte("body-c")}, {[]byte("d"), []byte("body-d")}})
+		return err
+	},
+	func(s *Stack) error { _, _, err := s.Pop(); return err },
+	func(s *Stack) error { _, err := s.PopN(1); return err },
+	func(s *Stack) error {
+		tx := s.Begin()
+		tx.Pop()
+		tx.Push([]byte("e"), []byte("body-e"))
+		tx.Push([]byte("f"), []byte("longer body-f"))
+		return tx.Commit()
+	},
+	func(s *Stack) error { _, err := s.ReplaceTop([]byte("g"), []byte("body-g")); return err },
+	func(s *Stack) error { _, err := s.UpdateTopHeader([]byte("h")); return err },
+	func(s *Stack) error { _, err := s.UpdateTopHeader([]byte("hh")); return err },
+	func(s *Stack) error { return s.Drain(func(header, data []byte) error { return nil }) },
+}
+
+// Content of stack as list of segments from bottom to top
+func stackContent(t *testing.T, s *Stack) string {
+	var content string
+	err := s.IterateForward(func(depth int, header io.Reader, body io.Reader) bool {
+		h, _ := ioutil.ReadAll(header)
+		b, _ := ioutil.ReadAll(body)
+		content += fmt.Sprintf("[%s:%s]", h, b)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// Storages of stack after crash
+type crashStorages struct {
+	file, blob, journal *MemoryStorage
+}
+
+func (cs *crashStorages) open(plan *faultPlan) (*Stack, error) {
+	var file, journal Storage = cs.file, cs.journal
+	var blob Storage
+	if cs.blob != nil {
+		blob = cs.blob
+	}
+	if plan != nil {
+		file, journal = &faultStorage{cs.file, plan}, &faultStorage{cs.journal, plan}
+		if cs.blob != nil {
+			blob = &faultStorage{cs.blob, plan}
+		}
+	}
+	logger := WithLogger(log.New(ioutil.Discard, "", 0))
+	if blob != nil {
+		return NewSplitStorageStack(file, blob, WithJournal(journal), logger)
+	}
+	return NewStorageStack(file, WithJournal(journal), logger)
+}
+
+func newCrashStorages(split bool) *crashStorages {
+	cs := &crashStorages{file: NewMemoryStorage(), journal: NewMemoryStorage()}
+	if split {
+		cs.blob = NewMemoryStorage()
+	}
+	return cs
+}
+
+// Run scenario with fault at operation failAt. Returns index of interrupted step
+// (len(crashScenario) if no crash) and count of operations
+func runCrashScenario(t *testing.T, cs *crashStorages, failAt, kind int) (step, ops int) {
+	plan := &faultPlan{failAt: -1}
+	stack, err := cs.open(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.failAt, plan.kind, plan.ops = failAt, kind, 0
+	for step = range crashScenario {
+		err = crashScenario[step](stack)
+		if plan.crashed {
+			return step, plan.ops
+		}
+		if err != nil {
+			t.Fatal("Step", step, "failed without fault:", err)
+		}
+	}
+	return len(crashScenario), plan.ops
+}
+
+func testCrashRecovery(t *testing.T, split bool) {
+	// Expected states after each step
+	cs := newCrashStorages(split)
+	stack, err := cs.open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := []string{stackContent(t, stack)}
+	for _, step := range crashScenario {
+		if err = step(stack); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, stackContent(t, stack))
+	}
+	_, total := runCrashScenario(t, newCrashStorages(split), -1, 0)
+	for kind := 0; kind < faultKinds; kind++ {
+		for failAt := 0; failAt < total; failAt++ {
+			cs := newCrashStorages(split)
+			step, _ := runCrashScenario(t, cs, failAt, kind)
+			stack, err := cs.open(nil)
+			if err != nil {
+				t.Fatal("Can't open after fault", kind, "at", failAt, ":", err)
+			}
+			content := stackContent(t, stack)
+			if content != states[step] && content != states[step+1] {
+				t.Fatal("Invalid state after fault", kind, "at", failAt, "in step", step, ":", content,
+					"expected", states[step], "or", states[step+1])
+			}
+			// Recovered stack is usable and stable
+			if _, err = stack.Push([]byte("x"), []byte("body-x")); err != nil {
+				t.Fatal(err)
+			}
+			if stack, err = cs.open(nil); err != nil {
+				t.Fatal(err)
+			}
+			if stackContent(t, stack) != content+"[x:body-x]" {
+				t.Fatal("Unexpected content after reopen", stackContent(t, stack))
+			}
+		}
+	}
+}
+
+func TestCrashRecovery(t *testing.T) { testCrashRecovery(t, false) }
+
+func TestSplitCrashRecovery(t *testing.T) { testCrashRecovery(t, true) }
+
+// Journal storage which loses truncate (not persisted by power loss)
+type lostTruncateStorage struct {
+	*MemoryStorage
+}
+
+func (ls *lostTruncateStorage) Truncate(size int64) error { return nil }
+
+func TestStackStaleJournal(t *testing.T) {
+	storage, journal := NewMemoryStorage(), &lostTruncateStorage{NewMemoryStorage()}
+	stack, err := NewStorageStack(storage, WithJournal(journal), WithSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("a"), []byte("body-a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.ReplaceTop([]byte("b"), []byte("body-b")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = stack.Push([]byte("c"), []byte("body-c")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Journal of finished replace is left, but stack was changed after it
+	for _, options := range [][]Option{{WithReadOnly()}, nil} {
+		options = append(options, WithJournal(journal), WithLogger(&testLogger{}))
+		stack, err = NewStorageStack(storage, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report := stack.LastRepair(); report.RolledBack {
+			t.Fatal("Stale journal must not be rolled back", report)
+		}
+		if content := stackContent(t, stack); content != "[b:body-b][c:body-c][c:body-c][c:body-c]" {
+			t.Fatal("Unexpected content", content)
+		}
+	}
+	// Stale journal is dropped after pop too
+	if _, err = stack.ReplaceTop([]byte("d"), []byte("body-d")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage, WithJournal(journal), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := stackContent(t, stack); content != "[b:body-b][c:body-c][c:body-c]" {
+		t.Fatal("Unexpected content", content)
+	}
+}
+
+func TestStackChecksumMismatch(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-0"), []byte("body-0")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-1"), []byte("body-1")); err != nil {
+		t.Fatal(err)
+	}
+	// Damage data of top block
+	if _, err = storage.WriteAt([]byte("X"), int64(stack.currentBlock.DataPoint)); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 1)
+}
@@ -0,0 +1,80 @@
+package fstack
+
+import "time"
+
+// PopN - pop up to n segments from top of stack by one truncate. Segments are returned in pop
+// order: from top to bottom. If stack has less then n segments, all of them are popped. ErrEmpty
+// returned for empty stack
+func (s *Stack) PopN(n int) ([]Message, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	if s.depth == 0 && n > 0 {
+		return nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	var messages []Message
+	err := s.popN(n, func(header, data []byte) error {
+		messages = append(messages, Message{Header: header, Data: data})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Drain - pop all segments from top to bottom and pass them to handler. Segments are removed by
+// one truncate only if handler processed all of them without error, otherwise stack is not
+// changed and error of handler is returned
+func (s *Stack) Drain(handler func(header, data []byte) error) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.lastAccess = time.Now()
+	return s.popN(s.depth, handler)
+}
+
+// Read up to n segments from top to bottom, pass them to handler and remove them if handler
+// returns no error. Guard must be locked
+func (s *Stack) popN(n int, handler func(header, data []byte) error) error {
+	if n > s.depth {
+		n = s.depth
+	}
+	if n <= 0 {
+		return nil
+	}
+	if s.options.readOnly {
+		// Handler must not process segments which will not be removed
+		return ErrReadOnly
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	blocks := make([]fileBlock, n)
+	headers := make([][]byte, n)
+	for i := range blocks {
+		block := s.currentBlock
+		if i > 0 {
+			block, err = s.blockAt(file, s.offsets[s.depth-1-i])
+			if err != nil {
+				return err
+			}
+		}
+		header, data, err := s.readBlock(&block)
+		if err != nil {
+			return err
+		}
+		if err = handler(header, data); err != nil {
+			return err
+		}
+		blocks[i] = block
+		headers[i] = header
+	}
+	return s.apply(blocks, headers, nil, 0, 0, false)
+}
@@ -0,0 +1,85 @@
+package fstack
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStackPopN(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.PushBatch(testBatch(10)); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := stack.PopN(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 || stack.Depth() != 7 {
+		t.Fatal("Unexpected count of popped segments", len(messages), stack.Depth())
+	}
+	for i, msg := range messages {
+		if string(msg.Header) != fmt.Sprint("header-", 9-i) || string(msg.Data) != fmt.Sprint("body-", 9-i) {
+			t.Fatal("Unexpected segment", string(msg.Header), string(msg.Data))
+		}
+	}
+	if _, err = stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	if stack.Depth() != 7 {
+		t.Fatal("Unexpected depth after repare", stack.Depth())
+	}
+	if seq, _ := stack.Push([]byte("header-7"), []byte("body-7")); seq != 11 {
+		t.Fatal("Sequence number reused after PopN", seq)
+	}
+	messages, err = stack.PopN(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 8 || stack.Depth() != 0 {
+		t.Fatal("Unexpected count of popped segments", len(messages), stack.Depth())
+	}
+}
+
+func TestStackDrain(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(5)); err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("failure")
+	var count int
+	err = stack.Drain(func(header, data []byte) error {
+		count++
+		if count == 3 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure {
+		t.Fatal("Error of handler expected, got", err)
+	}
+	if stack.Depth() != 5 {
+		t.Fatal("Stack must be not changed after failed drain", stack.Depth())
+	}
+	count = 0
+	err = stack.Drain(func(header, data []byte) error {
+		if string(header) != fmt.Sprint("header-", 4-count) {
+			t.Fatal("Unexpected header", string(header))
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || stack.Depth() != 0 {
+		t.Fatal("Not all segments drained", count, stack.Depth())
+	}
+}
@@ -0,0 +1,86 @@
+package fstack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedFormat returned when stack file has unknown format version
+var ErrUnsupportedFormat = errors.New("unsupported stack format version")
+
+// ErrLegacyFormat returned when stack file in legacy format (without super block) is opened in
+// read-only mode: file must be upgraded by opening in read-write mode first
+var ErrLegacyFormat = errors.New("stack file has legacy format and must be upgraded by read-write open")
+
+// ErrNotFound returned when requested segment is not in stack
+var ErrNotFound = errors.New("segment not found")
+
+// ErrLayout returned when stack file opened with wrong layout (split as interleaved or vice versa)
+var ErrLayout = errors.New("stack layout mismatch")
+
+// ErrNotEmpty returned when operation requires empty stack
+var ErrNotEmpty = errors.New("stack is not empty")
+
+// ErrNoIndex returned when operation requires index which was not added
+var ErrNoIndex = errors.New("index not found")
+
+// ErrClosed returned when stack is used after Close (see WithReopen)
+var ErrClosed = errors.New("stack is closed")
+
+// ErrFileChanged returned when released stack file can't be reopened: it's deleted, replaced or
+// modified by another process
+var ErrFileChanged = errors.New("stack file is deleted, replaced or modified")
+
+// ErrTxDone returned when transaction is used after Commit or Rollback
+var ErrTxDone = errors.New("transaction is already committed or rolled back")
+
+// ErrReadOnly returned by changes of stack opened in read-only mode
+var ErrReadOnly = errors.New("stack is opened in read-only mode")
+
+// ErrConflict returned by conditional operation when top of stack is not the expected segment
+var ErrConflict = errors.New("top segment changed")
+
+// ErrEmpty returned by operations which require segment on top of stack when stack is empty
+var ErrEmpty = errors.New("stack is empty")
+
+// ErrTooLarge returned when pushed segment is over limits (see WithMaxSize) or segment is too large
+// to be read into memory
+var ErrTooLarge = errors.New("segment is too large")
+
+// ErrLocked returned when stack file is opened (and locked) by another stack or process
+var ErrLocked = errors.New("stack file is locked")
+
+// ErrCorrupt matches (by errors.Is) all errors about damaged stack files (see CorruptError)
+var ErrCorrupt = errors.New("stack file is corrupted")
+
+// CorruptError - damaged block found in stack file
+type CorruptError struct {
+	Offset int64  // Offset of damaged block in file
+	Reason string // What is wrong
+}
+
+func (ce *CorruptError) Error() string {
+	return fmt.Sprintf("stack file is corrupted at %d: %s", ce.Offset, ce.Reason)
+}
+
+// Is - CorruptError matches ErrCorrupt
+func (ce *CorruptError) Is(target error) bool { return target == ErrCorrupt }
+
+// Convert unexpected end of storage while reading block at offset to CorruptError
+func corruptAt(offset int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &CorruptError{Offset: offset, Reason: "block is out of file"}
+	}
+	return err
+}
+
+// Check that size of segment part can be allocated
+func checkSize(size uint64) error {
+	if size > uint64(maxInt) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+const maxInt = int(^uint(0) >> 1)
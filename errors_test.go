@@ -0,0 +1,139 @@
+package fstack
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestStackErrEmpty(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Pop(); !errors.Is(err, ErrEmpty) {
+		t.Fatal("Pop of empty stack must fail, got", err)
+	}
+	if _, _, err = stack.Peak(); !errors.Is(err, ErrEmpty) {
+		t.Fatal("Peak of empty stack must fail, got", err)
+	}
+	if _, err = stack.PeakHeader(); !errors.Is(err, ErrEmpty) {
+		t.Fatal("PeakHeader of empty stack must fail, got", err)
+	}
+	if _, err = stack.PopN(1); !errors.Is(err, ErrEmpty) {
+		t.Fatal("PopN of empty stack must fail, got", err)
+	}
+	if _, err = stack.UpdateTopHeader([]byte("header")); !errors.Is(err, ErrEmpty) {
+		t.Fatal("UpdateTopHeader of empty stack must fail, got", err)
+	}
+	if err = stack.Drain(func(header, data []byte) error { return nil }); err != nil {
+		t.Fatal("Drain of empty stack must do nothing, got", err)
+	}
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("body")); !errors.Is(err, ErrClosed) {
+		t.Fatal("Push to closed stack must fail, got", err)
+	}
+}
+
+func TestStackCorruptError(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	top := stack.currentBlockPos
+	// Storage is cut behind the stack
+	if err = storage.Truncate(top + fileBlockDefineSize + 2); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = stack.Peak()
+	var corrupt *CorruptError
+	if !errors.Is(err, ErrCorrupt) || !errors.As(err, &corrupt) || corrupt.Offset != top {
+		t.Fatal("Corrupt error expected at", top, "got", err)
+	}
+}
+
+func TestStackLocked(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenStack("temp.stack"); !errors.Is(err, ErrLocked) {
+		t.Fatal("Second open must fail, got", err)
+	}
+	if _, err = OpenStack("temp.stack", WithReadOnly()); !errors.Is(err, ErrLocked) {
+		t.Fatal("Read-only open of locked stack must fail, got", err)
+	}
+	if _, err = CreateStack("temp.stack"); !errors.Is(err, ErrLocked) {
+		t.Fatal("Create must fail, got", err)
+	}
+	stack.Close()
+	reader, err := OpenStack("temp.stack", WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	second, err := OpenStack("temp.stack", WithReadOnly())
+	if err != nil {
+		t.Fatal("Read-only stacks must share file, got", err)
+	}
+	defer second.Close()
+	if second.Depth() != 1 {
+		t.Fatal("Locked stack must not be truncated, depth", second.Depth())
+	}
+	if _, err = OpenStack("temp.stack"); !errors.Is(err, ErrLocked) {
+		t.Fatal("Open of stack used by readers must fail, got", err)
+	}
+}
+
+func TestStackClosedEmpty(t *testing.T) {
+	file, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	memory, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stack := range []*Stack{file, memory} {
+		if err = stack.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Closed state is checked before depth
+		if _, _, err = stack.Pop(); !errors.Is(err, ErrClosed) {
+			t.Fatal("Pop of closed stack must fail, got", err)
+		}
+		if _, _, err = stack.Peak(); !errors.Is(err, ErrClosed) {
+			t.Fatal("Peak of closed stack must fail, got", err)
+		}
+		if _, err = stack.PeakHeader(); !errors.Is(err, ErrClosed) {
+			t.Fatal("PeakHeader of closed stack must fail, got", err)
+		}
+		if _, err = stack.PopN(0); !errors.Is(err, ErrClosed) {
+			t.Fatal("PopN of closed stack must fail, got", err)
+		}
+		if err = stack.Drain(func(header, data []byte) error { return nil }); !errors.Is(err, ErrClosed) {
+			t.Fatal("Drain of closed stack must fail, got", err)
+		}
+		if err = stack.IterateBackward(func(depth int, header io.Reader, body io.Reader) bool { return true }); !errors.Is(err, ErrClosed) {
+			t.Fatal("IterateBackward of closed stack must fail, got", err)
+		}
+		if err = stack.ScanHeaders(func(depth int, seq uint64, header []byte) bool { return true }); !errors.Is(err, ErrClosed) {
+			t.Fatal("ScanHeaders of closed stack must fail, got", err)
+		}
+		if _, err = stack.PushBatch(nil); !errors.Is(err, ErrClosed) {
+			t.Fatal("PushBatch to closed stack must fail, got", err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package fstack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header of segment decoded by JSON convention (as fstack utility pushes): object of key-value pairs
+type Header map[string]string
+
+// ParseHeader - decode JSON header. Non-string values are kept in JSON representation
+// (numbers as is, booleans as true/false)
+func ParseHeader(data []byte) (Header, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+	header := make(Header, len(raw))
+	for k, v := range raw {
+		switch value := v.(type) {
+		case string:
+			header[k] = value
+		case json.Number:
+			header[k] = value.String()
+		case nil:
+			header[k] = ""
+		default:
+			encoded, _ := json.Marshal(value)
+			header[k] = string(encoded)
+		}
+	}
+	return header, nil
+}
+
+// Filter of segments by decoded header
+type Filter interface {
+	Match(header Header) bool
+}
+
+// FilterFunc - function as filter
+type FilterFunc func(header Header) bool
+
+// Match header by function
+func (fn FilterFunc) Match(header Header) bool { return fn(header) }
+
+type eqFilter struct{ key, value string }
+
+func (f eqFilter) Match(header Header) bool {
+	value, ok := header[f.key]
+	return ok && value == f.value
+}
+
+func (f eqFilter) String() string { return f.key + "=" + f.value }
+
+// Eq - header has key with exact value
+func Eq(key, value string) Filter { return eqFilter{key, value} }
+
+type prefixFilter struct{ key, prefix string }
+
+func (f prefixFilter) Match(header Header) bool {
+	value, ok := header[f.key]
+	return ok && strings.HasPrefix(value, f.prefix)
+}
+
+func (f prefixFilter) String() string { return f.key + "^=" + f.prefix }
+
+// Prefix - header has key with value started by prefix
+func Prefix(key, prefix string) Filter { return prefixFilter{key, prefix} }
+
+type existsFilter struct{ key string }
+
+func (f existsFilter) Match(header Header) bool {
+	_, ok := header[f.key]
+	return ok
+}
+
+func (f existsFilter) String() string { return f.key }
+
+// Exists - header has key
+func Exists(key string) Filter { return existsFilter{key} }
+
+// Numeric comparison operators
+const (
+	opLess = iota
+	opLessOrEqual
+	opGreater
+	opGreaterOrEqual
+)
+
+var opNames = []string{"<", "<=", ">", ">="}
+
+type compareFilter struct {
+	key   string
+	op    int
+	value float64
+}
+
+func (f compareFilter) Match(header Header) bool {
+	text, ok := header[f.key]
+	if !ok {
+		return false
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return false
+	}
+	switch f.op {
+	case opLess:
+		return value < f.value
+	case opLessOrEqual:
+		return value <= f.value
+	case opGreater:
+		return value > f.value
+	case opGreaterOrEqual:
+		return value >= f.value
+	}
+	return false
+}
+
+func (f compareFilter) String() string { return fmt.Sprint(f.key, opNames[f.op], f.value) }
+
+// Lt - header has key with numeric value less then specified
+func Lt(key string, value float64) Filter { return compareFilter{key, opLess, value} }
+
+// Le - header has key with numeric value less or equal to specified
+func Le(key string, value float64) Filter { return compareFilter{key, opLessOrEqual, value} }
+
+// Gt - header has key with numeric value greater then specified
+func Gt(key string, value float64) Filter { return compareFilter{key, opGreater, value} }
+
+// Ge - header has key with numeric value greater or equal to specified
+func Ge(key string, value float64) Filter { return compareFilter{key, opGreaterOrEqual, value} }
+
+type andFilter []Filter
+
+func (f andFilter) Match(header Header) bool {
+	for _, filter := range f {
+		if !filter.Match(header) {
+			return false
+		}
+	}
+	return true
+}
+
+// And - all filters matched. Empty list matches everything
+func And(filters ...Filter) Filter { return andFilter(filters) }
+
+type orFilter []Filter
+
+func (f orFilter) Match(header Header) bool {
+	for _, filter := range f {
+		if filter.Match(header) {
+			return true
+		}
+	}
+	return false
+}
+
+// Or - at least one filter matched. Empty list matches nothing
+func Or(filters ...Filter) Filter { return orFilter(filters) }
+
+type notFilter struct{ filter Filter }
+
+func (f notFilter) Match(header Header) bool { return !f.filter.Match(header) }
+
+// Not - filter is not matched
+func Not(filter Filter) Filter { return notFilter{filter} }
+
+// IterateFiltered - iterate from bottom to top over segments which headers matched by filter.
+// Headers are scanned as in ScanHeaders and bodies of non-matched segments are never read.
+// Headers which can't be decoded are not matched. Header slice is valid only inside handler.
+// If filter is Eq (alone or inside And) on field with secondary index (see AddIndex), only
+// segments from index are checked. Return false from handler to stop iteration
+func (s *Stack) IterateFiltered(filter Filter, handler func(depth int, header []byte, body io.Reader) bool) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.lastAccess = time.Now()
+	if offsets, ok := s.indexedCandidates(filter); ok {
+		return s.iterateIndexed(offsets, filter, handler)
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return err
+	}
+	return s.scan(func(depth int, block *fileBlock, header []byte) bool {
+		decoded, err := ParseHeader(header)
+		if err != nil || !filter.Match(decoded) {
+			return true
+		}
+		body := io.NewSectionReader(dataFile, int64(block.DataPoint), int64(block.DataSize))
+		return handler(depth, header, body)
+	})
+}
@@ -0,0 +1,86 @@
+package fstack
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	header, err := ParseHeader([]byte(`{"device":"42","temp":21.5,"ok":true,"none":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Header{"device": "42", "temp": "21.5", "ok": "true", "none": ""}
+	for k, v := range expected {
+		if header[k] != v {
+			t.Fatal("Unexpected value of", k, ":", header[k], "!=", v)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	header := Header{"device": "42", "name": "sensor-1", "temp": "21.5"}
+	cases := []struct {
+		filter   Filter
+		expected bool
+	}{
+		{Eq("device", "42"), true},
+		{Eq("device", "43"), false},
+		{Prefix("name", "sensor-"), true},
+		{Prefix("name", "camera-"), false},
+		{Exists("temp"), true},
+		{Exists("humidity"), false},
+		{Gt("temp", 20), true},
+		{Ge("temp", 21.5), true},
+		{Lt("temp", 21.5), false},
+		{Le("temp", 21.5), true},
+		{Gt("name", 0), false},
+		{And(Eq("device", "42"), Gt("temp", 30)), false},
+		{Or(Eq("device", "43"), Gt("temp", 20)), true},
+		{Not(Exists("humidity")), true},
+	}
+	for i, c := range cases {
+		if c.filter.Match(header) != c.expected {
+			t.Error("Case", i, c.filter, "expected", c.expected)
+		}
+	}
+}
+
+func TestStackIterateFiltered(t *testing.T) {
+	N := 30
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	for i := 0; i < N; i++ {
+		header := fmt.Sprintf(`{"device":"%v","index":%v}`, i%3, i)
+		if _, err := stack.Push([]byte(header), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not JSON header must be skipped
+	if _, err := stack.Push([]byte("raw"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var found []int
+	err = stack.IterateFiltered(And(Eq("device", "1"), Ge("index", 10)), func(depth int, header []byte, body io.Reader) bool {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != fmt.Sprint("body-", depth) {
+			t.Fatal("Unexpected body", string(data), "at", depth)
+		}
+		found = append(found, depth)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(found) != "[10 13 16 19 22 25 28]" {
+		t.Fatal("Unexpected filtered segments", found)
+	}
+}
@@ -0,0 +1,153 @@
+package fstack
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// File layout:
+//
+//	[super block][block meta][header][data][trailer][block meta][header][data][trailer]...
+//
+// Super block is placed at the begining of file and describes format version.
+// First block has PrevBlock = 0 (points to super block). Trailer is commit marker of block
+// (see checksum.go), files created before markers have no trailers (flagChecksum is not set).
+const (
+	formatVersion  = 2
+	superBlockSize = 64
+)
+
+var formatMagic = [4]byte{'F', 'S', 'T', 'K'}
+
+// Meta-info at the begining of stack file
+type superBlock struct {
+	Magic        [4]byte // Always FSTK
+	Version      uint32  // Format version
+	NextSequence uint64  // High-water mark of sequence numbers: updated before removing blocks
+	Flags        uint64  // Layout and features of file
+	FileID       uint64  // Random identity of file: sequence numbers restart in recreated file
+	Reserved     [superBlockSize - 32]byte
+}
+
+// Flags of super block
+const (
+	flagSplit    uint64 = 1 << iota // Bodies are stored in separate blob file
+	flagChecksum                    // Each block ends by commit marker with checksum
+)
+
+func newSuperBlock() superBlock {
+	sb := superBlock{Magic: formatMagic, Version: formatVersion}
+	var id [8]byte
+	rand.Read(id[:])
+	sb.FileID = binary.LittleEndian.Uint64(id[:])
+	return sb
+}
+
+// Write super block to begining of file
+func (sb *superBlock) writeTo(writer io.WriterAt) error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, *sb)
+	_, err := writer.WriteAt(buf.Bytes(), 0)
+	return err
+}
+
+// Prepare file for stack: write super block with flags to empty file (if write is true) or check
+// existent. Returns true if file has legacy format (without super block)
+func initFormat(file Storage, flags uint64, write bool) (sb superBlock, legacy bool, err error) {
+	size, err := file.Size()
+	if err != nil {
+		return sb, false, err
+	}
+	if size == 0 {
+		sb = newSuperBlock()
+		sb.Flags = flags
+		if !write {
+			return sb, false, nil
+		}
+		return sb, false, sb.writeTo(file)
+	}
+	if size < superBlockSize {
+		return sb, true, nil
+	}
+	err = binary.Read(io.NewSectionReader(file, 0, superBlockSize), binary.LittleEndian, &sb)
+	if err != nil {
+		return sb, false, err
+	}
+	if sb.Magic != formatMagic {
+		return sb, true, nil
+	}
+	if sb.Version != formatVersion {
+		return sb, false, ErrUnsupportedFormat
+	}
+	return sb, false, nil
+}
+
+// Meta-info of block in first format version (without super block)
+type legacyBlock struct {
+	PrevBlock   uint64
+	HeaderPoint uint64
+	HeaderSize  uint64
+	DataPoint   uint64
+	DataSize    uint64
+}
+
+const legacyBlockDefineSize = 8 + 8 + 8 + 8 + 8
+
+// Convert stack file from legacy format to current. Blocks are copied from src (locked original
+// file) to temporary file which replaces original. New file is locked before rename, so file name
+// is never unlocked. Broken tail of legacy file is dropped (as Repare does). Push time of legacy
+// blocks is unknown, so it is set to zero. Returns super block of new file and the file
+func upgradeLegacy(src Storage, fileName string) (sb superBlock, file *os.File, err error) {
+	size, err := src.Size()
+	if err != nil {
+		return sb, nil, err
+	}
+	tmpName := fileName + ".upgrade"
+	dst, err := CreateStack(tmpName)
+	if err != nil {
+		return sb, nil, err
+	}
+	defer os.Remove(tmpName)
+	defer dst.Close()
+	var offset int64
+	for offset+legacyBlockDefineSize <= size {
+		var block legacyBlock
+		err = binary.Read(io.NewSectionReader(src, offset, legacyBlockDefineSize), binary.LittleEndian, &block)
+		if err != nil {
+			return sb, nil, err
+		}
+		next := int64(block.DataPoint + block.DataSize)
+		if next > size || next <= offset || block.HeaderPoint < uint64(offset) {
+			break
+		}
+		header := make([]byte, block.HeaderSize)
+		if _, err = src.ReadAt(header, int64(block.HeaderPoint)); err != nil {
+			return sb, nil, err
+		}
+		data := make([]byte, block.DataSize)
+		if _, err = src.ReadAt(data, int64(block.DataPoint)); err != nil {
+			return sb, nil, err
+		}
+		if err = dst.push(header, data, 0, dst.nextSeq); err != nil {
+			return sb, nil, err
+		}
+		offset = next
+	}
+	if err = dst.Close(); err != nil {
+		return sb, nil, err
+	}
+	if file, err = openLocked(tmpName, os.O_RDWR); err != nil {
+		return sb, nil, err
+	}
+	if err = os.Rename(tmpName, fileName); err == nil {
+		err = syncDir(fileName)
+	}
+	if err != nil {
+		file.Close()
+		return sb, nil, err
+	}
+	return dst.super, file, nil
+}
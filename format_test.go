@@ -0,0 +1,73 @@
+package fstack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Write stack file in legacy format with n segments
+func writeLegacyStack(t *testing.T, n int) {
+	file, err := os.Create("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset uint64
+	for i := 0; i < n; i++ {
+		header := []byte(fmt.Sprint("head-", i))
+		data := []byte(fmt.Sprint("data-", i))
+		block := legacyBlock{
+			PrevBlock:   offset,
+			HeaderPoint: offset + legacyBlockDefineSize,
+			HeaderSize:  uint64(len(header)),
+			DataPoint:   offset + legacyBlockDefineSize + uint64(len(header)),
+			DataSize:    uint64(len(data)),
+		}
+		binary.Write(file, binary.LittleEndian, block)
+		file.Write(header)
+		file.Write(data)
+		offset = block.DataPoint + block.DataSize
+	}
+	file.Close()
+}
+
+func TestStackUpgradeLegacy(t *testing.T) {
+	writeLegacyStack(t, 3)
+	stack, err := OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if stack.Depth() != 3 {
+		t.Fatal("Expected 3 segments after upgrade, got", stack.Depth())
+	}
+	if _, err = OpenStack("temp.stack"); !errors.Is(err, ErrLocked) {
+		t.Fatal("Upgraded file must be locked, got", err)
+	}
+	header, data, err := stack.Peak()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "head-2" || string(data) != "data-2" {
+		t.Fatal("Non-consisten data after upgrade:", string(header), string(data))
+	}
+}
+
+func TestStackReadOnlyLegacy(t *testing.T) {
+	writeLegacyStack(t, 2)
+	defer os.Remove("temp.stack")
+	original, err := ioutil.ReadFile("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenStack("temp.stack", WithReadOnly()); !errors.Is(err, ErrLegacyFormat) {
+		t.Fatal("Legacy format error expected, got", err)
+	}
+	if content, _ := ioutil.ReadFile("temp.stack"); !bytes.Equal(content, original) {
+		t.Fatal("Legacy file must not be changed in read-only mode")
+	}
+}
@@ -0,0 +1,185 @@
+package fstack
+
+import (
+	"io"
+	"sort"
+)
+
+// Secondary index: value of header field -> offsets of all segments with the value
+type valueIndex struct {
+	field   string
+	values  map[string][]int64 // Offsets are in ascending order (from bottom to top)
+	broken  bool               // Index must be rebuilt before usage
+	sidecar sidecar
+}
+
+func (vi *valueIndex) pushed(s *Stack, offset int64, block *fileBlock, header []byte) {
+	vi.sidecar.invalidate()
+	decoded, err := ParseHeader(header)
+	if err != nil {
+		return
+	}
+	if value, ok := decoded[vi.field]; ok {
+		vi.values[value] = append(vi.values[value], offset)
+	}
+}
+
+func (vi *valueIndex) popped(s *Stack, offset int64, block *fileBlock, header []byte) {
+	vi.sidecar.invalidate()
+	decoded, err := ParseHeader(header)
+	if err != nil {
+		return
+	}
+	value, ok := decoded[vi.field]
+	if !ok {
+		return
+	}
+	// Popped segment is always the newest one
+	offsets := vi.values[value]
+	if len(offsets) == 0 || offsets[len(offsets)-1] != offset {
+		vi.broken = true
+		return
+	}
+	if len(offsets) == 1 {
+		delete(vi.values, value)
+	} else {
+		vi.values[value] = offsets[:len(offsets)-1]
+	}
+}
+
+func (vi *valueIndex) rebuild(s *Stack) error {
+	vi.sidecar.invalidate()
+	vi.values = make(map[string][]int64)
+	offsets := s.offsets
+	err := s.scan(func(depth int, block *fileBlock, header []byte) bool {
+		decoded, err := ParseHeader(header)
+		if err != nil {
+			return true
+		}
+		if value, ok := decoded[vi.field]; ok {
+			vi.values[value] = append(vi.values[value], offsets[depth])
+		}
+		return true
+	})
+	vi.broken = err != nil
+	return err
+}
+
+func (vi *valueIndex) save(s *Stack) error {
+	if vi.broken {
+		return nil
+	}
+	return vi.sidecar.save(s, vi.values)
+}
+
+// AddIndex - maintain secondary index by header field (JSON header convention): value -> list of
+// segments. Index is used by IterateFiltered for Eq filter (alone or inside And) on the field.
+// Index is persisted to sidecar file on Close and loaded on next call for same field if stack was
+// not changed, otherwise it is rebuilt by scanning headers
+func (s *Stack) AddIndex(field string) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	return s.addIndex(field)
+}
+
+// Load or build secondary index of field (if it's not added yet). Guard must be locked
+func (s *Stack) addIndex(field string) error {
+	if s.valueIndexes[field] != nil {
+		return nil
+	}
+	idx := &valueIndex{field: field, sidecar: newSidecar(s, "index", field)}
+	idx.values = make(map[string][]int64)
+	if !idx.sidecar.load(s, &idx.values) {
+		if err := idx.rebuild(s); err != nil {
+			return err
+		}
+	}
+	if s.valueIndexes == nil {
+		s.valueIndexes = make(map[string]*valueIndex)
+	}
+	s.valueIndexes[field] = idx
+	s.indexes = append(s.indexes, idx)
+	return nil
+}
+
+// IndexedValues - get all values of indexed field with count of segments for each value.
+// Requires index added by AddIndex
+func (s *Stack) IndexedValues(field string) (map[string]int, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	idx := s.valueIndexes[field]
+	if idx == nil {
+		return nil, ErrNoIndex
+	}
+	if idx.broken {
+		if err := idx.rebuild(s); err != nil {
+			return nil, err
+		}
+	}
+	counts := make(map[string]int, len(idx.values))
+	for value, offsets := range idx.values {
+		counts[value] = len(offsets)
+	}
+	return counts, nil
+}
+
+// Find offsets of candidates for filter by secondary index. Returns false if filter can't use
+// indexes. Guard must be locked
+func (s *Stack) indexedCandidates(filter Filter) ([]int64, bool) {
+	switch f := filter.(type) {
+	case eqFilter:
+		idx := s.valueIndexes[f.key]
+		if idx == nil {
+			return nil, false
+		}
+		if idx.broken && idx.rebuild(s) != nil {
+			return nil, false
+		}
+		return idx.values[f.value], true
+	case andFilter:
+		for _, sub := range f {
+			if offsets, ok := s.indexedCandidates(sub); ok {
+				return offsets, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Iterate over candidates found by index and check them by filter. Guard must be locked
+func (s *Stack) iterateIndexed(offsets []int64, filter Filter, handler func(depth int, header []byte, body io.Reader) bool) error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return err
+	}
+	for _, offset := range offsets {
+		block, err := s.blockAt(file, offset)
+		if err != nil {
+			return err
+		}
+		header, err := s.readHeader(file, &block)
+		if err != nil {
+			return err
+		}
+		decoded, err := ParseHeader(header)
+		if err != nil || !filter.Match(decoded) {
+			continue
+		}
+		depth := sort.Search(len(s.offsets), func(i int) bool { return s.offsets[i] >= offset })
+		body := io.NewSectionReader(dataFile, int64(block.DataPoint), int64(block.DataSize))
+		if !handler(depth, header, body) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package fstack
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStackIndex(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.index.device")
+	if err = stack.AddIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		header := fmt.Sprintf(`{"device":"%v","index":%v}`, i%4, i)
+		if _, err := stack.Push([]byte(header), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	collect := func(filter Filter) string {
+		var found []int
+		err := stack.IterateFiltered(filter, func(depth int, header []byte, body io.Reader) bool {
+			data, err := ioutil.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != fmt.Sprint("body-", depth) {
+				t.Fatal("Unexpected body", string(data), "at", depth)
+			}
+			found = append(found, depth)
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(found)
+	}
+	if found := collect(Eq("device", "3")); found != "[3 7 11 15]" {
+		t.Fatal("Unexpected indexed segments", found)
+	}
+	if found := collect(And(Gt("index", 4), Eq("device", "1"))); found != "[5 9 13 17]" {
+		t.Fatal("Unexpected indexed segments", found)
+	}
+	counts, err := stack.IndexedValues("device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["0"] != 5 || counts["3"] != 4 {
+		t.Fatal("Unexpected counts", counts)
+	}
+	stack.Close()
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if err = stack.AddIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if !stack.valueIndexes["device"].sidecar.valid {
+		t.Fatal("Index must be loaded from sidecar file")
+	}
+	if found := collect(Eq("device", "2")); found != "[2 6 10 14 18]" {
+		t.Fatal("Unexpected indexed segments after reopen", found)
+	}
+	// Repare must keep index consistent
+	if _, err = stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	if found := collect(Eq("device", "0")); found != "[0 4 8 12 16]" {
+		t.Fatal("Unexpected indexed segments after repare", found)
+	}
+}
@@ -0,0 +1,316 @@
+package fstack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Undo journal keeps regions of stack storages which will be overwritten by operation and sizes
+// of storages: region of file and region of data storage (blob in split layout, same file in
+// interleaved layout). Journal is written and synced before the operation and cleared after it, so if
+// journal is found on open, operation was interrupted and regions are restored (operation is
+// rolled back). Journal is stamped by identity of file and by last sequence number written by
+// operation: journal left by finished operation (clear is lost by crash) doesn't match file
+// changed after it and is dropped.
+//
+// Layout: magic, stamp, offset, length and storage size for file and for data storage, regions,
+// CRC32C of header and regions and magic again as mark of complete journal
+const (
+	journalMagic      = "FSTJ"
+	journalHeaderSize = 4 + 8*8
+	journalTailSize   = 4 + 4
+	toEnd             = -1 // End of region is end of storage
+)
+
+// Header of undo journal
+type journalHeader struct {
+	FileID     uint64 // Identity of stack file (see superBlock)
+	LastSeq    uint64 // Last sequence number written by operation
+	FileOffset int64
+	FileLength int64
+	FileSize   int64
+	DataOffset int64
+	DataLength int64
+	DataSize   int64
+}
+
+// Storage of undo journal: sidecar file for file stacks, storage from WithJournal or temporary
+// memory storage (no crash-safety, rollback on errors only)
+func (s *Stack) getJournal() (Storage, error) {
+	if s.journal != nil {
+		return s.journal, nil
+	}
+	if s.options.journal != nil {
+		s.journal = s.options.journal
+		return s.journal, nil
+	}
+	if s.fileName == "" {
+		s.journal = NewMemoryStorage()
+		return s.journal, nil
+	}
+	f, err := os.OpenFile(s.journalName(), os.O_CREATE|os.O_RDWR, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = NewFileStorage(f)
+	return s.journal, nil
+}
+
+func (s *Stack) journalName() string { return s.fileName + ".journal" }
+
+// Save regions of file and of data storage to undo journal. End of region may be toEnd. Operation
+// writes blocks with sequence numbers up to lastSeq. Guard must be locked
+func (s *Stack) beginJournal(fileOffset, fileEnd, dataOffset, dataEnd int64, lastSeq uint64) error {
+	if s.options.readOnly {
+		return ErrReadOnly
+	}
+	journal, err := s.getJournal()
+	if err != nil {
+		return err
+	}
+	var header journalHeader
+	var buf bytes.Buffer
+	buf.WriteString(journalMagic)
+	header.FileID = s.super.FileID
+	header.LastSeq = lastSeq
+	header.FileOffset = fileOffset
+	fileRegion, fileSize, err := readRegion(s.getFile, fileOffset, fileEnd)
+	if err != nil {
+		return err
+	}
+	header.FileLength = int64(len(fileRegion))
+	header.FileSize = fileSize
+	header.DataOffset = dataOffset
+	dataRegion, dataSize, err := readRegion(s.getData, dataOffset, dataEnd)
+	if err != nil {
+		return err
+	}
+	header.DataLength = int64(len(dataRegion))
+	header.DataSize = dataSize
+	binary.Write(&buf, binary.LittleEndian, &header)
+	buf.Write(fileRegion)
+	buf.Write(dataRegion)
+	binary.Write(&buf, binary.LittleEndian, crc32.Checksum(buf.Bytes()[len(journalMagic):], castagnoli))
+	buf.WriteString(journalMagic)
+	err = journal.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = journal.WriteAt(buf.Bytes(), 0)
+	if err != nil {
+		return err
+	}
+	return journal.Sync()
+}
+
+// Region of blob from offset to end (bodies of top blocks) in split layout. In interleaved layout
+// bodies are in file, so region is empty
+func (s *Stack) blobTail(offset int64) (int64, int64) {
+	if s.split() {
+		return offset, toEnd
+	}
+	return 0, 0
+}
+
+// Read region of storage (limited by size of storage) and get size of storage
+func readRegion(get func() (Storage, error), offset, end int64) ([]byte, int64, error) {
+	storage, err := get()
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := storage.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if end == toEnd || end > size {
+		end = size
+	}
+	if offset >= end {
+		return nil, size, nil
+	}
+	region := make([]byte, end-offset)
+	_, err = storage.ReadAt(region, offset)
+	return region, size, err
+}
+
+// Operation finished: sync storages and clear journal. Guard must be locked
+func (s *Stack) endJournal() error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	if s.split() {
+		blob, err := s.getBlob()
+		if err != nil {
+			return err
+		}
+		if err = blob.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	return s.clearJournal()
+}
+
+// Remove journal content. Empty journal is synced before sidecar file is removed, so finished
+// operation is not rolled back after crash
+func (s *Stack) clearJournal() error {
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Truncate(0)
+	if err == nil {
+		err = s.journal.Sync()
+	}
+	if err != nil || s.options.journal != nil || s.fileName == "" {
+		return err
+	}
+	s.journal.Close()
+	s.journal = nil
+	if err = os.Remove(s.journalName()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(s.journalName())
+}
+
+// Sync directory of file to persist creation, rename or removal of file
+func syncDir(fileName string) error {
+	dir, err := os.Open(filepath.Dir(fileName))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Check that journal belongs to current state of stack: file has same identity and wasn't changed
+// after operation. Finished operation is followed by pushes (blocks with greater sequence numbers
+// after journaled region) or pops (greater high-water mark in super block). Guard must be locked
+func (s *Stack) journalMatches(header *journalHeader) (bool, error) {
+	if header.FileID != s.super.FileID || s.super.NextSequence > header.LastSeq {
+		return false, nil
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return false, err
+	}
+	size, err := file.Size()
+	if err != nil {
+		return false, err
+	}
+	// Committed blocks from begining of journaled region
+	for pos := header.FileOffset; pos+fileBlockDefineSize <= size; {
+		block, err := readBlockAt(file, pos)
+		if err != nil || int64(block.HeaderPoint) != pos+fileBlockDefineSize {
+			break
+		}
+		if s.checksums() {
+			if _, ok, err := s.readTrailer(file, &block); err != nil || !ok {
+				break
+			}
+		}
+		if block.Sequence > header.LastSeq {
+			return false, nil
+		}
+		next := s.blockEnd(&block)
+		if next <= pos {
+			break
+		}
+		pos = next
+	}
+	return true, nil
+}
+
+// Restore regions from complete journal (if any) and clear journal. Incomplete journal means that
+// storages were not changed yet. Returns true if operation was rolled back. Guard must be locked
+func (s *Stack) rollbackJournal() (bool, error) {
+	if s.journal == nil && s.options.journal == nil {
+		if s.fileName == "" {
+			return false, nil
+		}
+		if _, err := os.Stat(s.journalName()); os.IsNotExist(err) {
+			return false, nil
+		}
+	}
+	journal, err := s.getJournal()
+	if err != nil {
+		return false, err
+	}
+	content, err := readJournal(journal)
+	if err != nil {
+		return false, err
+	}
+	header, regions, ok := parseJournal(content)
+	if !ok {
+		if len(content) > 0 {
+			s.logger().Println("Incomplete journal", len(content), "bytes !drop!")
+		}
+		return false, s.clearJournal()
+	}
+	if ok, err = s.journalMatches(&header); err != nil {
+		return false, err
+	}
+	if !ok {
+		s.logger().Println("Stale journal of operation at", header.FileOffset, "!drop!")
+		return false, s.clearJournal()
+	}
+	s.logger().Println("Interrupted operation at", header.FileOffset, "!rollback!")
+	err = restoreRegion(s.getFile, header.FileOffset, regions[:header.FileLength], header.FileSize)
+	if err != nil {
+		return false, err
+	}
+	err = restoreRegion(s.getData, header.DataOffset, regions[header.FileLength:], header.DataSize)
+	if err != nil {
+		return false, err
+	}
+	return true, s.endJournal()
+}
+
+// Read content of journal
+func readJournal(journal Storage) ([]byte, error) {
+	size, err := journal.Size()
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, size)
+	_, err = journal.ReadAt(content, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return content, nil
+}
+
+// Decode complete journal: header and regions of file and data storage
+func parseJournal(content []byte) (header journalHeader, regions []byte, ok bool) {
+	if len(content) < journalHeaderSize+journalTailSize || string(content[:len(journalMagic)]) != journalMagic {
+		return header, nil, false
+	}
+	binary.Read(bytes.NewReader(content[len(journalMagic):journalHeaderSize]), binary.LittleEndian, &header)
+	tail := len(content) - journalTailSize
+	regions = content[journalHeaderSize:tail]
+	if string(content[tail+4:]) != journalMagic || header.FileLength < 0 || header.DataLength < 0 ||
+		int64(len(regions)) != header.FileLength+header.DataLength ||
+		binary.LittleEndian.Uint32(content[tail:]) != crc32.Checksum(content[len(journalMagic):tail], castagnoli) {
+		return header, nil, false
+	}
+	return header, regions, true
+}
+
+// Write region back and restore size of storage
+func restoreRegion(get func() (Storage, error), offset int64, region []byte, size int64) error {
+	storage, err := get()
+	if err != nil {
+		return err
+	}
+	_, err = storage.WriteAt(region, offset)
+	if err != nil {
+		return err
+	}
+	return storage.Truncate(size)
+}
@@ -0,0 +1,108 @@
+package fstack
+
+import (
+	"sort"
+	"time"
+)
+
+// Read header of block at offset. Guard must be locked
+func (s *Stack) readHeaderAt(offset int64) ([]byte, error) {
+	file, err := s.getFile()
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.blockAt(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	return s.readHeader(file, &block)
+}
+
+// Read header of block. Header must be inside stack and in limits. Guard must be locked
+func (s *Stack) readHeader(file Storage, block *fileBlock) ([]byte, error) {
+	if err := s.checkBlock(block, false); err != nil {
+		return nil, err
+	}
+	header := make([]byte, block.HeaderSize)
+	_, err := file.ReadAt(header, int64(block.HeaderPoint))
+	if err != nil {
+		return nil, corruptAt(block.offset(), err)
+	}
+	return header, nil
+}
+
+// AddLatestIndex - maintain index of newest segment for each value of header field (JSON header
+// convention). It's secondary index of the field (see AddIndex): newest segment is the last one
+// with the value, so after Pop previous segment becomes latest without scan
+func (s *Stack) AddLatestIndex(field string) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	return s.addIndex(field)
+}
+
+// Latest - get newest segment which header has field with specified value. Requires index
+// added by AddLatestIndex, otherwise ErrNoIndex returned. If there is no such segment,
+// ErrNotFound returned
+func (s *Stack) Latest(field, value string) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	s.lastAccess = time.Now()
+	idx, err := s.latestIndex(field)
+	if err != nil {
+		return nil, nil, err
+	}
+	offsets := idx.values[value]
+	if len(offsets) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	offset := offsets[len(offsets)-1]
+	file, err := s.getFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := s.blockAt(file, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.readBlock(&block)
+}
+
+// LatestValues - get all values of field which has at least one segment. Requires index added by
+// AddLatestIndex. Values are sorted
+func (s *Stack) LatestValues(field string) ([]string, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	idx, err := s.latestIndex(field)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(idx.values))
+	for value := range idx.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// Get usable index of field. Guard must be locked
+func (s *Stack) latestIndex(field string) (*valueIndex, error) {
+	idx := s.valueIndexes[field]
+	if idx == nil {
+		return nil, ErrNoIndex
+	}
+	if idx.broken {
+		if err := idx.rebuild(s); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
@@ -0,0 +1,169 @@
+package fstack
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestStackLatestIndex(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.index.device")
+	for i := 0; i < 10; i++ {
+		header := fmt.Sprintf(`{"device":"%v"}`, i%3)
+		if _, err := stack.Push([]byte(header), []byte(fmt.Sprint("state-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = stack.AddLatestIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	check := func(device, expected string) {
+		_, data, err := stack.Latest("device", device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatal("Unexpected latest state of", device, ":", string(data), "expected", expected)
+		}
+	}
+	check("0", "state-9")
+	check("1", "state-7")
+	check("2", "state-8")
+	if _, _, err = stack.Latest("device", "3"); err != ErrNotFound {
+		t.Fatal("Expected not found, got", err)
+	}
+	if _, _, err = stack.Latest("name", "3"); err != ErrNoIndex {
+		t.Fatal("Expected no index, got", err)
+	}
+	// Pop newest state of device 0 - previous must become latest
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	check("0", "state-6")
+	if _, err = stack.Push([]byte(`{"device":"1"}`), []byte("state-10")); err != nil {
+		t.Fatal(err)
+	}
+	check("1", "state-10")
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Index must be loaded from sidecar after restart
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if err = stack.AddLatestIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if !stack.valueIndexes["device"].sidecar.valid {
+		t.Fatal("Index must be loaded from sidecar file")
+	}
+	check("0", "state-6")
+	check("1", "state-10")
+	values, err := stack.LatestValues("device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(values) != "[0 1 2]" {
+		t.Fatal("Unexpected values", values)
+	}
+}
+
+// Storage which counts reads
+type countingStorage struct {
+	*MemoryStorage
+	reads int
+	bytes int // Total size of reads
+}
+
+func (cs *countingStorage) ReadAt(p []byte, offset int64) (int, error) {
+	cs.reads++
+	cs.bytes += len(p)
+	return cs.MemoryStorage.ReadAt(p, offset)
+}
+
+func TestStackLatestIndexPop(t *testing.T) {
+	storage := &countingStorage{MemoryStorage: NewMemoryStorage()}
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		header := fmt.Sprintf(`{"device":"%v"}`, i)
+		if _, err := stack.Push([]byte(header), []byte(fmt.Sprint("state-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = stack.Push([]byte(`{"device":"0"}`), []byte("state-100")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.AddLatestIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	// Pop of newest segment of each value must not scan stack
+	for i := 0; i < 50; i++ {
+		storage.reads = 0
+		if _, _, err = stack.Pop(); err != nil {
+			t.Fatal(err)
+		}
+		if storage.reads > 5 {
+			t.Fatal("Too many reads for pop:", storage.reads)
+		}
+	}
+	if _, data, err := stack.Latest("device", "0"); err != nil || string(data) != "state-0" {
+		t.Fatal("Unexpected latest state", string(data), err)
+	}
+	if _, _, err = stack.Latest("device", "99"); err != ErrNotFound {
+		t.Fatal("Expected not found, got", err)
+	}
+}
+
+func TestStackLatestIndexRecreated(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.index.device")
+	if _, err = stack.Push([]byte(`{"device":"A"}`), []byte("old-A")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.AddLatestIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.AddIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stack.indexes) != 1 {
+		t.Fatal("Latest and secondary indexes of field must be shared")
+	}
+	stack.Close()
+	// Sequence numbers restart in recreated file: sidecar files must not be used
+	stack, err = CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.Push([]byte(`{"device":"B"}`), []byte("new-B")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.AddLatestIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.AddIndex("device"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Latest("device", "A"); err != ErrNotFound {
+		t.Fatal("Segment of old file found", err)
+	}
+	if _, data, err := stack.Latest("device", "B"); err != nil || string(data) != "new-B" {
+		t.Fatal("Unexpected latest state", string(data), err)
+	}
+	if counts, err := stack.IndexedValues("device"); err != nil || fmt.Sprint(counts) != "map[B:1]" {
+		t.Fatal("Unexpected indexed values", counts, err)
+	}
+}
@@ -0,0 +1,81 @@
+package fstack
+
+import (
+	"os"
+	"time"
+)
+
+// Release - close file handles (and release locks) of stack keeping it open: files are reopened
+// on next access. Reopen fails with ErrFileChanged if file is deleted, replaced or modified
+// meanwhile. Does nothing for stack over custom storage
+func (s *Stack) Release() error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if s.fileName == "" {
+		return nil
+	}
+	return s.release()
+}
+
+// Check that stack is not closed. Guard must be locked
+func (s *Stack) checkOpen() error {
+	if s.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Release handles if stack is not accessed during idle timeout, otherwise wait rest of timeout.
+// Runs by idle timer
+func (s *Stack) releaseIdle() {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if s.closed || s.file == nil && s.blob == nil {
+		return
+	}
+	idle := time.Since(s.lastAccess)
+	if idle < s.options.idleTimeout {
+		s.idleTimer.Reset(s.options.idleTimeout - idle)
+		return
+	}
+	if err := s.release(); err != nil {
+		s.logger().Println("Release of idle stack", s.fileName, "failed:", err)
+	}
+}
+
+// File of storage opened by stack. Returns nil for custom storage
+func storageFile(storage Storage) *os.File {
+	if rs, ok := storage.(*readOnlyStorage); ok {
+		storage = rs.Storage
+	}
+	switch st := storage.(type) {
+	case *mmapStorage:
+		return st.File
+	case *fileStorage:
+		return st.File
+	}
+	return nil
+}
+
+// Identity of file of storage: inode, size and modification time. Returns nil if unknown
+func identity(storage Storage) os.FileInfo {
+	file := storageFile(storage)
+	if file == nil {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// Check that opened file is same and not modified since info was taken
+func sameFile(file *os.File, info os.FileInfo) bool {
+	current, err := file.Stat()
+	return err == nil && os.SameFile(current, info) && current.Size() == info.Size() &&
+		current.ModTime().Equal(info.ModTime())
+}
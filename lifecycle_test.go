@@ -0,0 +1,140 @@
+package fstack
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStackClosed(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Peak(); err != ErrClosed {
+		t.Fatal("Access after Close must fail, got", err)
+	}
+	if err = stack.Release(); err != ErrClosed {
+		t.Fatal("Release after Close must fail, got", err)
+	}
+	stack, err = OpenStack("temp.stack", WithReopen())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if header, _, err := stack.Peak(); err != nil || string(header) != "header" {
+		t.Fatal("Stack must be reopened", string(header), err)
+	}
+}
+
+func TestStackReleaseReplaced(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	defer stack.Close()
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.Release(); err != nil {
+		t.Fatal(err)
+	}
+	// Lock is released: file is available for other stacks
+	other, err := OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, _, err = stack.Peak(); err != nil {
+		t.Fatal("Not modified file must be reopened, got", err)
+	}
+	if err = stack.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove("temp.stack"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Peak(); err != ErrFileChanged {
+		t.Fatal("Deleted file must not be reopened, got", err)
+	}
+	other, err = CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, _, err = stack.Peak(); err != ErrFileChanged {
+		t.Fatal("Replaced file must not be reopened, got", err)
+	}
+}
+
+func TestStackReleaseModified(t *testing.T) {
+	stack, err := CreateSplitStack("temp.stack", "temp.stack.blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	defer os.Remove("temp.stack.blob")
+	defer stack.Close()
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.Release(); err != nil {
+		t.Fatal(err)
+	}
+	other, err := OpenSplitStack("temp.stack", "temp.stack.blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != ErrFileChanged {
+		t.Fatal("Modified file must not be reopened, got", err)
+	}
+}
+
+func TestStackIdleTimeout(t *testing.T) {
+	stack, err := CreateStack("temp.stack", WithIdleTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack")
+	defer stack.Close()
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	released := func() bool {
+		stack.guard.Lock()
+		defer stack.guard.Unlock()
+		return stack.file == nil
+	}
+	if released() {
+		t.Fatal("Handle of used stack is released")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !released() {
+		t.Fatal("Handle of idle stack is not released")
+	}
+	if header, _, err := stack.Peak(); err != nil || string(header) != "header" {
+		t.Fatal("Stack must be reopened", string(header), err)
+	}
+	if released() {
+		t.Fatal("Handle is not reopened")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !released() {
+		t.Fatal("Handle of reopened stack is not released")
+	}
+}
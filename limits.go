@@ -0,0 +1,97 @@
+package fstack
+
+import (
+	"fmt"
+	"io"
+)
+
+// Check that header and data are in limits (see WithMaxSize)
+func (s *Stack) overLimit(headerSize, dataSize uint64) bool {
+	opts := &s.options
+	return opts.maxHeader > 0 && headerSize > uint64(opts.maxHeader) ||
+		opts.maxBody > 0 && dataSize > uint64(opts.maxBody)
+}
+
+// Check that segments can be pushed: ErrTooLarge returned if any of them is over limits
+func (s *Stack) checkPush(messages []Message) error {
+	for _, message := range messages {
+		if s.overLimit(uint64(len(message.Header)), uint64(len(message.Data))) {
+			return ErrTooLarge
+		}
+	}
+	return nil
+}
+
+// Check sizes of block from file against limits before segment is read. Data is not checked if
+// only header is read
+func (s *Stack) checkLimits(block *fileBlock, withData bool) error {
+	dataSize := block.DataSize
+	if !withData {
+		dataSize = 0
+	}
+	if !s.overLimit(block.HeaderSize, dataSize) {
+		return nil
+	}
+	return &CorruptError{
+		Offset: block.offset(),
+		Reason: fmt.Sprint("header of ", block.HeaderSize, " bytes or body of ", block.DataSize, " bytes is over limit"),
+	}
+}
+
+// Check block before header and data (if withData) are read: sizes must be in limits and parts of
+// block must be inside stack. Ends of files are tracked by stack (end of top block), so check
+// does not require system calls. Guard must be locked
+func (s *Stack) checkBlock(block *fileBlock, withData bool) error {
+	err := s.checkLimits(block, withData)
+	if err == nil {
+		err = checkRange(block.HeaderPoint, block.HeaderSize, s.nextBlockPoint())
+	}
+	if err == nil && withData {
+		err = checkRange(block.DataPoint, block.DataSize, s.nextDataPoint())
+	}
+	return corruptAt(block.offset(), err)
+}
+
+// Check structure of block at offset against sizes of files: meta-info points to header right
+// after it, data follows header (previous data in split layout) and whole block is inside files.
+// Returns reason of problem or empty string
+func (s *Stack) checkShape(block *fileBlock, offset, nextData, fileSize, dataSize int64) string {
+	dataPoint := uint64(nextData)
+	if !s.split() {
+		dataPoint = block.HeaderPoint + block.HeaderSize
+	}
+	switch {
+	case int64(block.HeaderPoint) != offset+fileBlockDefineSize:
+		return fmt.Sprint("header point ", block.HeaderPoint, " is not after meta info")
+	case checkRange(block.HeaderPoint, block.HeaderSize, fileSize) != nil:
+		return fmt.Sprint("header of ", block.HeaderSize, " bytes is out of file (", fileSize, ")")
+	case block.DataPoint != dataPoint:
+		return fmt.Sprint("data point ", block.DataPoint, ", expected ", dataPoint)
+	case checkRange(block.DataPoint, block.DataSize, dataSize) != nil:
+		return fmt.Sprint("data of ", block.DataSize, " bytes is out of file (", dataSize, ")")
+	case s.blockEnd(block) > fileSize:
+		return "commit marker is out of file"
+	}
+	return ""
+}
+
+// Check that size bytes at offset are before end: io.ErrUnexpectedEOF returned otherwise
+func checkRange(offset, size uint64, end int64) error {
+	if offset > uint64(end) || size > uint64(end)-offset {
+		return io.ErrUnexpectedEOF
+	}
+	return checkSize(size)
+}
+
+// Read meta-info of block at offset and check that it points to header right after it. Guard must
+// be locked
+func (s *Stack) blockAt(file Storage, offset int64) (fileBlock, error) {
+	block, err := readBlockAt(file, offset)
+	if err != nil {
+		return block, corruptAt(offset, err)
+	}
+	if int64(block.HeaderPoint) != offset+fileBlockDefineSize {
+		return block, &CorruptError{Offset: offset, Reason: fmt.Sprint("header point ", block.HeaderPoint, " is not after meta info")}
+	}
+	return block, nil
+}
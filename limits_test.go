@@ -0,0 +1,138 @@
+package fstack
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestStackMaxSizePush(t *testing.T) {
+	stack, err := NewMemoryStack(WithMaxSize(8, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-10"), []byte("body")); err != ErrTooLarge {
+		t.Fatal("Large header must not be pushed, got", err)
+	}
+	if _, err = stack.Push([]byte("header"), make([]byte, 17)); err != ErrTooLarge {
+		t.Fatal("Large body must not be pushed, got", err)
+	}
+	batch := []Message{{Header: []byte("h1"), Data: []byte("d1")}, {Header: []byte("h2"), Data: make([]byte, 17)}}
+	if _, err = stack.PushBatch(batch); err != ErrTooLarge || stack.Depth() != 0 {
+		t.Fatal("Batch with large segment must not be pushed", stack.Depth(), err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.ReplaceTop([]byte("header"), make([]byte, 17)); err != ErrTooLarge {
+		t.Fatal("Large body must not replace top, got", err)
+	}
+	if _, err = stack.UpdateTopHeader([]byte("header-10")); err != ErrTooLarge {
+		t.Fatal("Large header must not replace header, got", err)
+	}
+	tx := stack.Begin()
+	tx.Push([]byte("header-10"), nil)
+	if err = tx.Commit(); err != ErrTooLarge || stack.Depth() != 1 {
+		t.Fatal("Transaction with large segment must not be committed", stack.Depth(), err)
+	}
+	if header, data, err := stack.Peak(); err != nil || string(header) != "header" || string(data) != "body" {
+		t.Fatal("Unexpected top", string(header), string(data), err)
+	}
+}
+
+func TestStackMaxSizeRead(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("large body")); err != nil {
+		t.Fatal(err)
+	}
+	top := stack.currentBlockPos
+	size, _ := storage.Size()
+	// Stack with segment over limits is not opened and segment is kept
+	_, err = NewStorageStack(storage, WithMaxSize(8, 4), WithLogger(&testLogger{}))
+	var corrupt *CorruptError
+	if !errors.As(err, &corrupt) || corrupt.Offset != top {
+		t.Fatal("Corrupt error expected, got", err)
+	}
+	if current, _ := storage.Size(); current != size {
+		t.Fatal("Segment over limits must not be dropped")
+	}
+	stack, err = NewStorageStack(storage, WithMaxSize(8, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := stack.Peak(); err != nil || string(data) != "large body" {
+		t.Fatal("Unexpected segment", string(data), err)
+	}
+}
+
+func TestStackCorruptHeaderSize(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = stack.Push([]byte(`{"kind":"a"}`), []byte("body")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := stack.offsets[1]
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], 1<<62)
+	if _, err = storage.WriteAt(size[:], second+16); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage, WithMaxSize(1024, 1024), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := stack.LastRepair(); report.FirstBadOffset != second || stack.Depth() != 1 {
+		t.Fatal("Block with broken header size must be dropped", report, stack.Depth())
+	}
+	var count int
+	err = stack.ScanHeaders(func(depth int, seq uint64, header []byte) bool {
+		count++
+		return true
+	})
+	if err != nil || count != 1 {
+		t.Fatal("Unexpected scan", count, err)
+	}
+}
+
+func TestStackAbsurdSizes(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := stack.Push([]byte("header-0"), []byte("body-0"))
+	second, _ := stack.Push([]byte("header-1"), []byte("body-1"))
+	bottom := stack.offsets[0]
+	// Damage meta-info of blocks after open: body of terabytes
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], 1<<40)
+	if _, err = storage.WriteAt(size[:], bottom+32); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *CorruptError
+	if _, _, err = stack.GetBySeq(first); !errors.As(err, &corrupt) || corrupt.Offset != bottom {
+		t.Fatal("Corrupt error expected at", bottom, "got", err)
+	}
+	// Header of top block is out of file
+	stack.currentBlock.HeaderSize = 1 << 40
+	if _, _, err = stack.Peak(); !errors.As(err, &corrupt) || corrupt.Offset != stack.currentBlockPos {
+		t.Fatal("Corrupt error expected at", stack.currentBlockPos, "got", err)
+	}
+	// Meta-info must point to header right after it
+	binary.LittleEndian.PutUint64(size[:], 0)
+	if _, err = storage.WriteAt(size[:], stack.currentBlockPos+8); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.GetBySeq(second); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("Corrupt error expected, got", err)
+	}
+}
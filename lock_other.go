@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fstack
+
+import "os"
+
+func lockFile(file *os.File, shared bool) error { return nil }
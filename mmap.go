@@ -0,0 +1,190 @@
+package fstack
+
+import (
+	"io"
+	"time"
+)
+
+// Minimal size of memory mapping
+const minMappingSize = 1024 * 1024
+
+// Storage which can provide zero-copy access to content
+type viewer interface {
+	// View returns content of storage without copy. Slice is valid till next modification of storage
+	view(offset, size int64) ([]byte, error)
+}
+
+// File storage mapped to memory for reading. Mapping is larger then file and recreated when
+// file grows over it. Only ranges inside file are accessed, so truncation does not require remap
+type mmapStorage struct {
+	*fileStorage
+	data []byte
+}
+
+func (ms *mmapStorage) view(offset, size int64) ([]byte, error) {
+	end := offset + size
+	if end > int64(len(ms.data)) {
+		if err := ms.remap(end); err != nil {
+			return nil, err
+		}
+	}
+	return ms.data[offset:end:end], nil
+}
+
+// Map file again to cover at least end bytes
+func (ms *mmapStorage) remap(end int64) error {
+	fileSize, err := ms.Size()
+	if err != nil {
+		return err
+	}
+	if end > fileSize {
+		// Access to mapping beyond end of file is fatal
+		return io.ErrUnexpectedEOF
+	}
+	length := int64(minMappingSize)
+	for length < fileSize {
+		length *= 2
+	}
+	data, err := mmap(ms.File, length)
+	if err != nil {
+		return err
+	}
+	if ms.data != nil {
+		munmap(ms.data)
+	}
+	ms.data = data
+	return nil
+}
+
+// Close mapping and file
+func (ms *mmapStorage) Close() error {
+	if ms.data != nil {
+		munmap(ms.data)
+		ms.data = nil
+	}
+	return ms.fileStorage.Close()
+}
+
+// Make sure that mapping covers storage up to end, so following views will not be invalidated by
+// remap. Does nothing for other storages
+func prepareView(storage Storage, end int64) error {
+	if rs, ok := storage.(*readOnlyStorage); ok {
+		storage = rs.Storage
+	}
+	if ms, ok := storage.(*mmapStorage); ok && end > int64(len(ms.data)) {
+		return ms.remap(end)
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) view(offset, size int64) ([]byte, error) {
+	ms.guard.RLock()
+	defer ms.guard.RUnlock()
+	end := offset + size
+	if end > int64(len(ms.data)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return ms.data[offset:end:end], nil
+}
+
+// Apply options to storage
+func (s *Stack) wrap(storage Storage) Storage {
+	if fs, ok := storage.(*fileStorage); ok && s.options.mmap && mmapSupported {
+		return &mmapStorage{fileStorage: fs}
+	}
+	return storage
+}
+
+// Get part of storage without copy if storage supports it, otherwise read to buffer. Returns
+// slice and buffer (maybe grown). Guard must be locked
+func (s *Stack) viewOrRead(storage Storage, offset int64, size uint64, buf []byte) ([]byte, []byte, error) {
+	if err := checkSize(size); err != nil {
+		return nil, buf, err
+	}
+	if v, ok := storage.(viewer); ok {
+		data, err := v.view(offset, int64(size))
+		return data, buf, err
+	}
+	buf = grow(buf, size)
+	_, err := storage.ReadAt(buf, offset)
+	return buf, buf, err
+}
+
+// PeakFunc - get segment from top of stack without remove and without copy (if stack opened
+// WithMmap or in memory). Header and data are valid only inside handler and must not be
+// modified. Stack is locked while handler runs. Handler is not called and ErrEmpty is returned
+// for empty stack. Returns error of handler
+func (s *Stack) PeakFunc(handler func(header, data []byte) error) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if s.depth == 0 {
+		return ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	if cached, ok := s.cachedTop(); ok {
+		return handler(cached.header, cached.data)
+	}
+	if err := s.checkBlock(&s.currentBlock, true); err != nil {
+		return err
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return err
+	}
+	if !s.split() {
+		// Header and data are in same mapping: remap for data must not invalidate header
+		end := s.currentBlock.HeaderPoint + s.currentBlock.HeaderSize
+		if dataEnd := s.currentBlock.DataPoint + s.currentBlock.DataSize; dataEnd > end {
+			end = dataEnd
+		}
+		if err = prepareView(file, int64(end)); err != nil {
+			return err
+		}
+	}
+	var header, data []byte
+	header, s.headerBuf, err = s.viewOrRead(file, int64(s.currentBlock.HeaderPoint), s.currentBlock.HeaderSize, s.headerBuf)
+	if err != nil {
+		return corruptAt(s.currentBlockPos, err)
+	}
+	data, s.dataBuf, err = s.viewOrRead(dataFile, int64(s.currentBlock.DataPoint), s.currentBlock.DataSize, s.dataBuf)
+	if err != nil {
+		return corruptAt(s.currentBlockPos, err)
+	}
+	return handler(header, data)
+}
+
+// PeakHeaderFunc - get header from top of stack without remove and without copy (see PeakFunc)
+func (s *Stack) PeakHeaderFunc(handler func(header []byte) error) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if s.depth == 0 {
+		return ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	if cached, ok := s.cachedTop(); ok {
+		return handler(cached.header)
+	}
+	if err := s.checkBlock(&s.currentBlock, false); err != nil {
+		return err
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	var header []byte
+	header, s.headerBuf, err = s.viewOrRead(file, int64(s.currentBlock.HeaderPoint), s.currentBlock.HeaderSize, s.headerBuf)
+	if err != nil {
+		return corruptAt(s.currentBlockPos, err)
+	}
+	return handler(header)
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package fstack
+
+import (
+	"errors"
+	"os"
+)
+
+const mmapSupported = false
+
+func mmap(file *os.File, length int64) ([]byte, error) {
+	return nil, errors.New("mmap is not supported")
+}
+
+func munmap(data []byte) error { return nil }
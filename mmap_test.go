@@ -0,0 +1,92 @@
+package fstack
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStackPeakFuncMmap(t *testing.T) {
+	stack, err := CreateStack("temp.stack", WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	called := false
+	stack.PeakFunc(func(header, data []byte) error { called = true; return nil })
+	if called {
+		t.Fatal("Handler must not be called for empty stack")
+	}
+	big := bytes.Repeat([]byte{'x'}, minMappingSize)
+	for i := 0; i < 3; i++ {
+		// Each push grows file over mapping
+		if _, err = stack.Push([]byte(fmt.Sprint("header-", i)), append(big, byte('0'+i))); err != nil {
+			t.Fatal(err)
+		}
+		err = stack.PeakFunc(func(header, data []byte) error {
+			if string(header) != fmt.Sprint("header-", i) {
+				t.Fatal("Unexpected header", string(header))
+			}
+			if len(data) != len(big)+1 || data[len(big)] != byte('0'+i) {
+				t.Fatal("Unexpected data at", i)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	err = stack.PeakHeaderFunc(func(header []byte) error {
+		if string(header) != "header-1" {
+			t.Fatal("Unexpected header after pop", string(header))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stack.file.(*mmapStorage); mmapSupported && !ok {
+		t.Fatal("Expected mapped storage")
+	}
+}
+
+func TestStackPeakFuncFallback(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.Push([]byte("header"), []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	err = stack.PeakFunc(func(header, data []byte) error {
+		if string(header) != "header" || string(data) != "data" {
+			t.Fatal("Unexpected segment", string(header), string(data))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkStackPeakFuncMmap(b *testing.B) {
+	stack, err := CreateStack("temp.stack", WithMmap())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.Push([]byte("112233"), []byte("AAABBBCCC")); err != nil {
+		b.Fatal(err)
+	}
+	handler := func(header, data []byte) error { return nil }
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := stack.PeakFunc(handler); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
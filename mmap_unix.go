@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package fstack
+
+import (
+	"os"
+	"syscall"
+)
+
+const mmapSupported = true
+
+// Map file to memory for reading
+func mmap(file *os.File, length int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error { return syscall.Munmap(data) }
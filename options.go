@@ -0,0 +1,87 @@
+package fstack
+
+import (
+	"log"
+	"time"
+)
+
+// Option of stack behaviour. Options are applied when stack is opened or created
+type Option func(opts *options)
+
+type options struct {
+	mmap      bool    // Map files to memory for zero-copy reads
+	cacheSize int64   // Limit of cache of top segments in bytes. 0 means no cache
+	sync      bool    // Sync storages after each push
+	journal   Storage // Storage for undo journal of transactions
+	logger    Logger  // Destination of messages about repairs
+	readOnly  bool    // Never modify files
+	reopen    bool    // Reopen files on access after Close
+	maxHeader int64   // Limit of header size. 0 means no limit
+	maxBody   int64   // Limit of body size. 0 means no limit
+	// Release file handles after this time without access. 0 means never
+	idleTimeout time.Duration
+}
+
+// WithMmap - map stack files to memory for read-heavy usage: PeakFunc and PeakHeaderFunc
+// will serve segments without copy and system calls. Ignored on platforms without mmap support
+// and for custom storages
+func WithMmap() Option { return func(opts *options) { opts.mmap = true } }
+
+// WithCache - keep newest segments in memory up to maxBytes of headers and bodies in total. Cache
+// is populated on Push and invalidated on Pop, so Peak, PeakHeader and Pop of cached segments
+// don't touch storage. See CacheStats for hit/miss counters
+func WithCache(maxBytes int64) Option { return func(opts *options) { opts.cacheSize = maxBytes } }
+
+// WithSync - commit pushed segments to durable media (fsync) before Push or PushBatch returns.
+// Batch is synced once
+func WithSync() Option { return func(opts *options) { opts.sync = true } }
+
+// WithJournal - use storage for undo journal of transactions (see Tx). By default file stack
+// keeps journal in sidecar file and stack over custom storage keeps journal in memory, so it's
+// rolled back on errors but not after crash
+func WithJournal(storage Storage) Option { return func(opts *options) { opts.journal = storage } }
+
+// WithReadOnly - open stack without any modification of files: they are opened read-only, damage
+// is reported by LastRepair but not repaired, interrupted operation is rolled back only in view and
+// sidecar files are not written. Only valid prefix of stack is visible. Changes return ErrReadOnly,
+// legacy files can't be opened (ErrLegacyFormat)
+func WithReadOnly() Option { return func(opts *options) { opts.readOnly = true } }
+
+// WithReopen - allow access to file stack after Close: files are reopened automatically (and
+// closed again by next Close). Without this option any access after Close returns ErrClosed
+func WithReopen() Option { return func(opts *options) { opts.reopen = true } }
+
+// WithIdleTimeout - release file handles and locks (see Release) of file stack which is not
+// accessed (see LastAccess) during timeout. Files are reopened on next access
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(opts *options) { opts.idleTimeout = timeout }
+}
+
+// WithMaxSize - limit sizes of header and body of segment (0 means no limit). Larger segments are
+// not pushed (ErrTooLarge). Stack with larger blocks in file is not opened: CorruptError returned,
+// blocks are not dropped. Sizes of blocks are always checked against length of files
+func WithMaxSize(maxHeader, maxBody int64) Option {
+	return func(opts *options) { opts.maxHeader, opts.maxBody = maxHeader, maxBody }
+}
+
+// Logger - destination of messages about repairs and background errors. *log.Logger implements it
+type Logger interface {
+	Println(v ...interface{})
+}
+
+// Logger of standard log package
+type stdLogger struct{}
+
+func (stdLogger) Println(v ...interface{}) { log.Println(v...) }
+
+// WithLogger - write messages about repairs and background errors to logger instead of standard
+// log package. See RepairReport for structured result of repair
+func WithLogger(logger Logger) Option { return func(opts *options) { opts.logger = logger } }
+
+// Logger from options or standard log
+func (s *Stack) logger() Logger {
+	if s.options.logger != nil {
+		return s.options.logger
+	}
+	return stdLogger{}
+}
@@ -0,0 +1,172 @@
+package fstack
+
+import (
+	"sort"
+	"time"
+)
+
+// Cursor over range of segments from bottom to top of stack. Cursor does not hold lock
+// between calls, so if segments are removed from stack by Pop the iteration stops early:
+// segments pushed in place of removed ones are not returned unless they are in time range
+type Cursor struct {
+	stack   *Stack
+	index   int    // index of current block (depth from bottom)
+	end     int    // index of first block out of range
+	to      int64  // Upper bound of push time. 0 means unlimited
+	lastSeq uint64 // Sequence number of last returned block
+	block   fileBlock
+	err     error
+}
+
+// Next moves cursor to next segment. Returns false if no more segments or error occurred
+func (c *Cursor) Next() bool {
+	if c.err != nil || c.index+1 >= c.end {
+		return false
+	}
+	s := c.stack
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if c.index+1 >= len(s.offsets) {
+		return false
+	}
+	file, err := s.getFile()
+	if err != nil {
+		c.err = err
+		return false
+	}
+	block, err := s.blockAt(file, s.offsets[c.index+1])
+	if err != nil {
+		c.err = err
+		return false
+	}
+	// Stack is changed after previous call: block is pushed later or out of range
+	if block.Sequence <= c.lastSeq || c.to != 0 && block.Timestamp >= c.to {
+		c.end = c.index + 1
+		return false
+	}
+	c.index++
+	c.block = block
+	c.lastSeq = block.Sequence
+	return true
+}
+
+// Depth of current segment - index from bottom of stack (same as in IterateForward)
+func (c *Cursor) Depth() int { return c.index }
+
+// Time of push of current segment
+func (c *Cursor) Time() time.Time { return c.block.Time() }
+
+// Seq - sequence number of current segment
+func (c *Cursor) Seq() uint64 { return c.block.Sequence }
+
+// Header of current segment
+func (c *Cursor) Header() ([]byte, error) {
+	s := c.stack
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	file, err := s.getFile()
+	if err != nil {
+		return nil, err
+	}
+	return s.readHeader(file, &c.block)
+}
+
+// Body of current segment
+func (c *Cursor) Body() ([]byte, error) {
+	s := c.stack
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkBlock(&c.block, true); err != nil {
+		return nil, err
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, c.block.DataSize)
+	if _, err = dataFile.ReadAt(data, int64(c.block.DataPoint)); err != nil {
+		return nil, corruptAt(c.block.offset(), err)
+	}
+	return data, nil
+}
+
+// Err - first error occurred during iteration
+func (c *Cursor) Err() error { return c.err }
+
+// RangeByTime - get cursor over segments pushed in time range [from, to). Zero time means
+// unlimited bound. Start and end of range are found by binary search over push time
+func (s *Stack) RangeByTime(from, to time.Time) (*Cursor, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	s.lastAccess = time.Now()
+	start := 0
+	end := len(s.offsets)
+	var toTime int64
+	var err error
+	if !from.IsZero() {
+		timestamp := from.UnixNano()
+		start, _, err = s.search(func(block *fileBlock) bool { return block.Timestamp >= timestamp })
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !to.IsZero() {
+		timestamp := to.UnixNano()
+		toTime = timestamp
+		end, _, err = s.search(func(block *fileBlock) bool { return block.Timestamp >= timestamp })
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Cursor{stack: s, index: start - 1, end: end, to: toTime}, nil
+}
+
+// GetBySeq - get segment by sequence number (returned by Push). Returns ErrNotFound if
+// there is no such segment (never pushed or already popped)
+func (s *Stack) GetBySeq(seq uint64) (header, data []byte, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	s.lastAccess = time.Now()
+	index, block, err := s.search(func(block *fileBlock) bool { return block.Sequence >= seq })
+	if err != nil {
+		return nil, nil, err
+	}
+	if index >= len(s.offsets) || block.Sequence != seq {
+		return nil, nil, ErrNotFound
+	}
+	return s.readBlock(&block)
+}
+
+// Binary search of first block (from bottom) which satisfies predicate. Predicate must be
+// monotonic (time and sequence number are). Returns index and meta-info of found
+// block. Guard must be locked
+func (s *Stack) search(predicate func(block *fileBlock) bool) (int, fileBlock, error) {
+	var found fileBlock
+	file, err := s.getFile()
+	if err != nil {
+		return 0, found, err
+	}
+	var readErr error
+	index := sort.Search(len(s.offsets), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		block, err := s.blockAt(file, s.offsets[i])
+		if err != nil {
+			readErr = err
+			return true
+		}
+		if predicate(&block) {
+			found = block
+			return true
+		}
+		return false
+	})
+	return index, found, readErr
+}
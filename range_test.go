@@ -0,0 +1,149 @@
+package fstack
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStackRangeByTime(t *testing.T) {
+	N := 10
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	var points []time.Time
+	for i := 0; i < N; i++ {
+		points = append(points, time.Now())
+		_, err := stack.Push([]byte(fmt.Sprint(i)), []byte(fmt.Sprint("body-", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cursor, err := stack.RangeByTime(points[3], points[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 3
+	for cursor.Next() {
+		header, err := cursor.Header()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := cursor.Body()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(header) != fmt.Sprint(expected) || string(body) != fmt.Sprint("body-", expected) {
+			t.Fatal("Unexpected segment", string(header), string(body), "expected", expected)
+		}
+		if cursor.Depth() != expected {
+			t.Fatal("Unexpected depth", cursor.Depth(), "expected", expected)
+		}
+		if cursor.Time().Before(points[3]) || !cursor.Time().Before(points[7]) {
+			t.Fatal("Segment out of time range:", cursor.Time())
+		}
+		expected++
+	}
+	if cursor.Err() != nil {
+		t.Fatal(cursor.Err())
+	}
+	if expected != 7 {
+		t.Fatal("Not all segments in range iterated:", expected)
+	}
+	// Unlimited bounds
+	cursor, err = stack.RangeByTime(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for cursor.Next() {
+		count++
+	}
+	if count != N {
+		t.Fatal("Expected all segments in unlimited range, got", count)
+	}
+}
+
+func TestStackGetBySeq(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for i := 0; i < 5; i++ {
+		seq, err := stack.Push([]byte(fmt.Sprint(i)), []byte(fmt.Sprint("body-", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	header, data, err := stack.GetBySeq(seqs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "2" || string(data) != "body-2" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	if _, _, err = stack.GetBySeq(seqs[4]); err != ErrNotFound {
+		t.Fatal("Popped segment must not be found, got", err)
+	}
+	stack.Close()
+	// Sequence number must not be reused after pop and reopen
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	seq, err := stack.Push(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != seqs[4]+1 {
+		t.Fatal("Sequence number reused or skipped:", seq, "expected", seqs[4]+1)
+	}
+}
+
+func TestStackRangeChanged(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	var points []time.Time
+	for i := 0; i < 5; i++ {
+		points = append(points, time.Now())
+		if _, err = stack.Push([]byte(fmt.Sprint(i)), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cursor, err := stack.RangeByTime(time.Time{}, points[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.Next() || cursor.Seq() != 1 {
+		t.Fatal("First segment expected", cursor.Seq(), cursor.Err())
+	}
+	// Segments in range are replaced by segments pushed later
+	if _, err = stack.PopN(4); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = stack.Push([]byte(fmt.Sprint("late-", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cursor.Next() {
+		header, _ := cursor.Header()
+		t.Fatal("Segment out of range returned", string(header), cursor.Time())
+	}
+	if cursor.Next() || cursor.Err() != nil {
+		t.Fatal("Iteration must be stopped", cursor.Err())
+	}
+}
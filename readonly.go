@@ -0,0 +1,152 @@
+package fstack
+
+import (
+	"io"
+	"os"
+)
+
+// Region of storage restored by rollback of interrupted operation: content at offset and size of
+// storage
+type journalRegion struct {
+	offset int64
+	data   []byte
+	size   int64
+	next   *journalRegion // Another region of same storage (data region in interleaved layout)
+}
+
+// Storage of stack in read-only mode: all changes are rejected. Overlay (if any) shows storage as
+// it will be after rollback of interrupted operation, so undo journal is applied without writes
+type readOnlyStorage struct {
+	Storage
+	overlay *journalRegion
+}
+
+func (rs *readOnlyStorage) ReadAt(p []byte, offset int64) (int, error) {
+	ov := rs.overlay
+	if ov == nil {
+		return rs.Storage.ReadAt(p, offset)
+	}
+	if offset >= ov.size {
+		return 0, io.EOF
+	}
+	buf := p
+	if int64(len(buf)) > ov.size-offset {
+		buf = p[:ov.size-offset]
+	}
+	read, err := rs.Storage.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return read, err
+	}
+	// Rollback extends storage by zeros
+	for i := read; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	for ; ov != nil; ov = ov.next {
+		start, end := offset, offset+int64(len(buf))
+		if start < ov.offset {
+			start = ov.offset
+		}
+		if regionEnd := ov.offset + int64(len(ov.data)); end > regionEnd {
+			end = regionEnd
+		}
+		if start < end {
+			copy(buf[start-offset:end-offset], ov.data[start-ov.offset:end-ov.offset])
+		}
+	}
+	if len(buf) < len(p) {
+		return len(buf), io.EOF
+	}
+	return len(buf), nil
+}
+
+func (rs *readOnlyStorage) WriteAt(p []byte, offset int64) (int, error) { return 0, ErrReadOnly }
+
+func (rs *readOnlyStorage) Truncate(size int64) error { return ErrReadOnly }
+
+func (rs *readOnlyStorage) Sync() error { return nil }
+
+func (rs *readOnlyStorage) Size() (int64, error) {
+	if rs.overlay != nil {
+		return rs.overlay.size, nil
+	}
+	return rs.Storage.Size()
+}
+
+func (rs *readOnlyStorage) view(offset, size int64) ([]byte, error) {
+	if v, ok := rs.Storage.(viewer); ok && rs.overlay == nil {
+		return v.view(offset, size)
+	}
+	data := make([]byte, size)
+	_, err := rs.ReadAt(data, offset)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// Protect storage of stack in read-only mode
+func (s *Stack) protect(storage Storage, overlay *journalRegion) Storage {
+	if !s.options.readOnly {
+		return storage
+	}
+	return &readOnlyStorage{Storage: storage, overlay: overlay}
+}
+
+// Show storages as after rollback of interrupted operation (if any) without writes. Returns true
+// if journal is complete. Guard must be locked
+func (s *Stack) viewJournal() (bool, error) {
+	journal := s.options.journal
+	if journal == nil {
+		if s.fileName == "" {
+			return false, nil
+		}
+		f, err := os.Open(s.journalName())
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		journal = NewFileStorage(f)
+		defer journal.Close()
+	}
+	content, err := readJournal(journal)
+	if err != nil {
+		return false, err
+	}
+	header, regions, ok := parseJournal(content)
+	if !ok {
+		return false, nil
+	}
+	if ok, err = s.journalMatches(&header); err != nil || !ok {
+		return false, err
+	}
+	s.logger().Println("Interrupted operation at", header.FileOffset, "!rollback! (read-only view)")
+	s.fileView = &journalRegion{offset: header.FileOffset, data: regions[:header.FileLength], size: header.FileSize}
+	s.file.(*readOnlyStorage).overlay = s.fileView
+	dataView := &journalRegion{offset: header.DataOffset, data: regions[header.FileLength:], size: header.DataSize}
+	if s.split() {
+		s.blobView = dataView
+		s.blob.(*readOnlyStorage).overlay = s.blobView
+	} else if len(dataView.data) > 0 {
+		s.fileView.next = dataView
+	}
+	return true, nil
+}
+
+// Is read-only mode requested by options
+func readOnly(opts []Option) bool {
+	var o options
+	for _, option := range opts {
+		option(&o)
+	}
+	return o.readOnly
+}
+
+// Flags to open files of stack
+func openMode(readOnly bool) int {
+	if readOnly {
+		return os.O_RDONLY
+	}
+	return os.O_CREATE | os.O_RDWR
+}
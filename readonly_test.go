@@ -0,0 +1,179 @@
+package fstack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStackReadOnly(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range testBatch(3) {
+		if _, err = stack.Push(message.Header, message.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stack.Close()
+	// Torn tail of last block
+	if err = os.Truncate("temp.stack", stack.currentBlockPos+10); err != nil {
+		t.Fatal(err)
+	}
+	original, err := ioutil.ReadFile("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, err = OpenStack("temp.stack", WithReadOnly(), WithReopen(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.index.kind")
+	if err = stack.AddIndex("kind"); err != nil {
+		t.Fatal(err)
+	}
+	if report := stack.LastRepair(); !report.Damaged() || report.BlocksScanned != 2 || report.BytesTruncated != 10 {
+		t.Fatal("Unexpected report", report)
+	}
+	if header, data, err := stack.Peak(); err != nil || string(header) != "header-1" || string(data) != "body-1" {
+		t.Fatal("Unexpected top", string(header), string(data), err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != ErrReadOnly {
+		t.Fatal("Push:", err)
+	}
+	if _, _, err = stack.Pop(); err != ErrReadOnly {
+		t.Fatal("Pop:", err)
+	}
+	var drained int
+	err = stack.Drain(func(header, data []byte) error { drained++; return nil })
+	if err != ErrReadOnly || drained != 0 {
+		t.Fatal("Drain:", drained, err)
+	}
+	if _, err = stack.ReplaceTop([]byte("header"), []byte("body")); err != ErrReadOnly {
+		t.Fatal("ReplaceTop:", err)
+	}
+	if _, err = stack.UpdateTopHeader([]byte("header")); err != ErrReadOnly {
+		t.Fatal("UpdateTopHeader:", err)
+	}
+	tx := stack.Begin()
+	tx.Pop()
+	if err = tx.Commit(); err != ErrReadOnly {
+		t.Fatal("Commit:", err)
+	}
+	if _, err = stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Reopen after Close is read-only too
+	if stack.Depth() != 2 || stack.TopSeq() != 2 {
+		t.Fatal("Unexpected state", stack.Depth(), stack.TopSeq())
+	}
+	if _, err = stack.PeakHeader(); err != nil {
+		t.Fatal(err)
+	}
+	stack.Close()
+	content, err := ioutil.ReadFile("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, original) {
+		t.Fatal("File is modified")
+	}
+	if _, err = os.Stat("temp.stack.index.kind"); !os.IsNotExist(err) {
+		t.Fatal("Sidecar file is written", err)
+	}
+	if _, err = CreateStack("temp.stack", WithReadOnly()); err != ErrReadOnly {
+		t.Fatal("CreateStack:", err)
+	}
+	if _, err = OpenStack("temp.missing.stack", WithReadOnly()); !os.IsNotExist(err) {
+		t.Fatal("Missing stack is opened:", err)
+	}
+}
+
+func TestStackReadOnlyJournal(t *testing.T) {
+	storage, blob, journal := NewMemoryStorage(), NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewSplitStorageStack(storage, blob, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	// Operation over top segment is interrupted
+	if err = stack.beginJournal(stack.currentBlockPos, toEnd, int64(stack.currentBlock.DataPoint), toEnd, stack.nextSeq); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Truncate(stack.currentBlockPos + 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = blob.WriteAt([]byte("garbage"), int64(stack.currentBlock.DataPoint)+2); err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte(nil), storage.Bytes()...)
+	originalBlob := append([]byte(nil), blob.Bytes()...)
+	originalJournal := append([]byte(nil), journal.Bytes()...)
+	stack, err = NewSplitStorageStack(storage, blob, WithJournal(journal), WithReadOnly(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := stack.LastRepair(); !report.RolledBack || report.BlocksScanned != 2 {
+		t.Fatal("Unexpected report", report)
+	}
+	var depth int
+	err = stack.PeakFunc(func(header, data []byte) error {
+		if string(header) != "header-1" || string(data) != "body-1" {
+			t.Fatal("Unexpected top", string(header), string(data))
+		}
+		depth = stack.depth
+		return nil
+	})
+	if err != nil || depth != 2 {
+		t.Fatal(depth, err)
+	}
+	if !bytes.Equal(storage.Bytes(), original) || !bytes.Equal(blob.Bytes(), originalBlob) ||
+		!bytes.Equal(journal.Bytes(), originalJournal) {
+		t.Fatal("Storage is modified")
+	}
+	// Writable stack rolls back operation
+	stack, err = NewSplitStorageStack(storage, blob, WithJournal(journal), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 2)
+}
+
+func TestStackReadOnlyJournalRegions(t *testing.T) {
+	storage, journal := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewStorageStack(storage, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	// Update of header is interrupted: header and commit marker around data are changed
+	block := stack.currentBlock
+	header, end := int64(block.HeaderPoint), stack.blockEnd(&block)
+	if err = stack.beginJournal(stack.currentBlockPos, header+int64(block.HeaderSize), end-blockTrailerSize, end, stack.nextSeq); err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{header, end - blockTrailerSize} {
+		if _, err = storage.WriteAt([]byte("garbage!"), offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stack, err = NewStorageStack(storage, WithJournal(journal), WithReadOnly(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := stack.LastRepair(); !report.RolledBack || stack.Depth() != 2 {
+		t.Fatal("Unexpected report", report, stack.Depth())
+	}
+	if header, data, err := stack.Peak(); err != nil || string(header) != "header-1" || string(data) != "body-1" {
+		t.Fatal("Unexpected top", string(header), string(data), err)
+	}
+}
@@ -0,0 +1,38 @@
+package fstack
+
+// RepairReport - result of stack repair (on open or by Repare)
+type RepairReport struct {
+	BlocksScanned  int   `json:"blocks_scanned"`   // Number of valid blocks found in file
+	BytesTruncated int64 `json:"bytes_truncated"`  // Bytes dropped from file and blob
+	BackRefsFixed  int   `json:"back_refs_fixed"`  // Number of rewritten back references
+	FirstBadOffset int64 `json:"first_bad_offset"` // Offset in file of first damaged or dropped block, -1 if none
+	RolledBack     bool  `json:"rolled_back"`      // Interrupted operation was rolled back by undo journal
+}
+
+// Damaged - data was dropped, rewritten or rolled back by repair
+func (rr RepairReport) Damaged() bool {
+	return rr.BytesTruncated > 0 || rr.BackRefsFixed > 0 || rr.FirstBadOffset >= 0 || rr.RolledBack
+}
+
+// Mark block at offset as damaged
+func (rr *RepairReport) bad(offset int64) {
+	if rr.FirstBadOffset < 0 || offset < rr.FirstBadOffset {
+		rr.FirstBadOffset = offset
+	}
+}
+
+// Drop tail of storage after offset
+func (rr *RepairReport) truncate(storage Storage, size, offset int64) {
+	storage.Truncate(offset)
+	if size > offset {
+		rr.BytesTruncated += size - offset
+	}
+}
+
+// LastRepair - report of last repair: on open, by Repare or by full IterateForward. Use it to
+// detect data dropped on startup
+func (s *Stack) LastRepair() RepairReport {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return s.repair
+}
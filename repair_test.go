@@ -0,0 +1,81 @@
+package fstack
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Logger which keeps messages
+type testLogger struct {
+	lines []string
+}
+
+func (tl *testLogger) Println(v ...interface{}) { tl.lines = append(tl.lines, fmt.Sprintln(v...)) }
+
+func TestStackRepairReport(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = stack.Push([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if report := stack.LastRepair(); report.Damaged() || report.BlocksScanned != 0 {
+		t.Fatal("Unexpected report of new stack", report)
+	}
+	top := stack.currentBlockPos
+	size, _ := storage.Size()
+	// Torn tail of last block
+	if err = storage.Truncate(size - 3); err != nil {
+		t.Fatal(err)
+	}
+	logger := &testLogger{}
+	stack, err = NewStorageStack(storage, WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := stack.LastRepair()
+	if !report.Damaged() || report.BlocksScanned != 2 || report.FirstBadOffset != top ||
+		report.BytesTruncated != size-3-top || report.BackRefsFixed != 0 || report.RolledBack {
+		t.Fatal("Unexpected report", report)
+	}
+	if len(logger.lines) != 1 || !strings.Contains(logger.lines[0], "!trunc!") {
+		t.Fatal("Unexpected log", logger.lines)
+	}
+	// Nothing to repair
+	report, err = stack.Repare()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Damaged() || report.BlocksScanned != 2 || report.FirstBadOffset != -1 {
+		t.Fatal("Unexpected report", report)
+	}
+	checkBatchStack(t, stack, 2)
+}
+
+func TestStackRepairReportRollback(t *testing.T) {
+	storage, journal := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewStorageStack(storage, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-0"), []byte("body-0")); err != nil {
+		t.Fatal(err)
+	}
+	// Interrupted operation
+	if err = stack.beginJournal(stack.currentBlockPos, toEnd, 0, 0, stack.nextSeq); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage, WithJournal(journal), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := stack.LastRepair(); !report.Damaged() || !report.RolledBack || report.BlocksScanned != 1 {
+		t.Fatal("Unexpected report", report)
+	}
+	checkBatchStack(t, stack, 1)
+}
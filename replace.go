@@ -0,0 +1,189 @@
+package fstack
+
+import "time"
+
+// ReplaceTop - replace segment on top of stack by new header and data. New block is written in
+// place of old one and replacement is crash-safe (by undo journal). Replaced segment gets new push
+// time and new sequence number which is returned. ErrEmpty returned for empty stack
+func (s *Stack) ReplaceTop(header, data []byte) (seq uint64, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return 0, err
+	}
+	s.lastAccess = time.Now()
+	if s.depth == 0 {
+		return 0, ErrEmpty
+	}
+	if err = s.checkPush([]Message{{Header: header, Data: data}}); err != nil {
+		return 0, err
+	}
+	seq = s.nextSeq
+	return seq, s.replaceTop(header, data, s.pushTimestamp(), seq)
+}
+
+// UpdateTopHeader - replace header of segment on top of stack keeping data (see ReplaceTop). Data
+// is not rewritten if new header has same size or stack is in split layout
+func (s *Stack) UpdateTopHeader(header []byte) (seq uint64, err error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return 0, err
+	}
+	s.lastAccess = time.Now()
+	if s.depth == 0 {
+		return 0, ErrEmpty
+	}
+	if err = s.checkPush([]Message{{Header: header}}); err != nil {
+		return 0, err
+	}
+	seq = s.nextSeq
+	timestamp := s.pushTimestamp()
+	if !s.split() && uint64(len(header)) != s.currentBlock.HeaderSize {
+		// Data follows header and must be moved
+		_, data, err := s.readBlock(&s.currentBlock)
+		if err != nil {
+			return 0, err
+		}
+		return seq, s.replaceTop(header, data, timestamp, seq)
+	}
+	return seq, s.updateTopHeader(header, timestamp, seq)
+}
+
+// Header of top block from cache or storage. Guard must be locked
+func (s *Stack) topHeader() ([]byte, error) {
+	if cached, ok := s.cachedTop(); ok {
+		return cached.header, nil
+	}
+	return s.readHeaderAt(s.currentBlockPos)
+}
+
+// Logically remove top block without truncate: new block will be written at same place.
+// Guard must be locked
+func (s *Stack) detachTop() (old fileBlock, oldHeader []byte, err error) {
+	old = s.currentBlock
+	oldHeader, err = s.topHeader()
+	if err != nil {
+		return old, nil, err
+	}
+	var prev fileBlock
+	if old.PrevBlock != 0 {
+		file, err := s.getFile()
+		if err != nil {
+			return old, nil, err
+		}
+		prev, err = s.blockAt(file, int64(old.PrevBlock))
+		if err != nil {
+			return old, nil, err
+		}
+	}
+	s.popped(&old, oldHeader, prev)
+	return old, oldHeader, nil
+}
+
+// Write new block in place of top block and cut rest of old one. Guard must be locked
+func (s *Stack) replaceTop(header, data []byte, timestamp int64, seq uint64) error {
+	dataOffset, dataEnd := s.blobTail(int64(s.currentBlock.DataPoint))
+	err := s.beginJournal(s.currentBlockPos, toEnd, dataOffset, dataEnd, seq)
+	if err != nil {
+		return err
+	}
+	if _, _, err = s.detachTop(); err != nil {
+		return s.rollback(err)
+	}
+	err = s.pushBatch([]Message{{Header: header, Data: data}}, timestamp, seq, false)
+	if err == nil {
+		err = s.truncateTail()
+	}
+	if err != nil {
+		return s.rollback(err)
+	}
+	return s.endJournal()
+}
+
+// Rewrite meta-info and header of top block keeping data in place: data is neither read nor
+// journaled. Header must have same size in interleaved layout. Guard must be locked
+func (s *Stack) updateTopHeader(header []byte, timestamp int64, seq uint64) error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	pos := s.currentBlockPos
+	block := s.currentBlock
+	block.HeaderSize = uint64(len(header))
+	block.Timestamp = timestamp
+	block.Sequence = seq
+	var data []byte
+	if cached, ok := s.cachedTop(); ok {
+		data = cached.data
+	}
+	// Meta-info, header and commit marker are in tail of file in split layout. In interleaved
+	// layout data is between header and commit marker
+	fileEnd, markerOffset, markerEnd := int64(toEnd), int64(0), int64(0)
+	if !s.split() {
+		fileEnd = int64(block.HeaderPoint + block.HeaderSize)
+		if s.checksums() {
+			markerOffset, markerEnd = s.blockEnd(&block)-blockTrailerSize, s.blockEnd(&block)
+		}
+	}
+	err = s.beginJournal(pos, fileEnd, markerOffset, markerEnd, seq)
+	if err != nil {
+		return err
+	}
+	old, oldHeader, err := s.detachTop()
+	if err != nil {
+		return s.rollback(err)
+	}
+	var crc uint32
+	if s.checksums() {
+		var ok bool
+		crc, ok, err = s.readTrailer(file, &old)
+		if err == nil && !ok {
+			err = &CorruptError{Offset: pos, Reason: "commit marker of top block not found"}
+		}
+		if err != nil {
+			return s.rollback(err)
+		}
+		crc = updateChecksum(crc, blockChecksum(&old, oldHeader, nil), blockChecksum(&block, header, nil), block.DataSize)
+	}
+	var meta [fileBlockDefineSize]byte
+	block.encode(meta[:])
+	buf := append(append(s.writeBuf[:0], meta[:]...), header...)
+	s.writeBuf = buf
+	_, err = file.WriteAt(buf, pos)
+	if err == nil && s.checksums() {
+		err = s.writeTrailer(&block, crc)
+	}
+	if err == nil && s.split() {
+		err = file.Truncate(s.blockEnd(&block))
+	}
+	if err != nil {
+		return s.rollback(err)
+	}
+	s.pushed(pos, &block, header, data)
+	if data == nil {
+		// Data of segment is unknown and can't be cached
+		s.cacheReset()
+	}
+	if seq >= s.nextSeq {
+		s.nextSeq = seq + 1
+	}
+	return s.endJournal()
+}
+
+// Cut storages after top block. Guard must be locked
+func (s *Stack) truncateTail() error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(s.nextBlockPoint())
+	if err != nil || !s.split() {
+		return err
+	}
+	blob, err := s.getBlob()
+	if err != nil {
+		return err
+	}
+	return blob.Truncate(s.nextDataPoint())
+}
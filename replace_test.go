@@ -0,0 +1,151 @@
+package fstack
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStackReplaceTop(t *testing.T) {
+	stack, err := CreateStack("temp.stack", WithCache(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.ReplaceTop([]byte("header"), []byte("body")); err != ErrEmpty {
+		t.Fatal("Replace in empty stack must fail, got", err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("old header"), []byte("long old body")); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := stack.ReplaceTop([]byte("header-2"), []byte("body-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 4 || stack.TopSeq() != 4 || stack.Depth() != 3 {
+		t.Fatal("Unexpected state after replace", seq, stack.TopSeq(), stack.Depth())
+	}
+	header, data, err := stack.Peak()
+	if err != nil || string(header) != "header-2" || string(data) != "body-2" {
+		t.Fatal("Unexpected top", string(header), string(data), err)
+	}
+	if _, err = os.Stat("temp.stack.journal"); !os.IsNotExist(err) {
+		t.Fatal("Journal must be removed after replace")
+	}
+	stack.Close()
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	checkBatchStack(t, stack, 3)
+}
+
+func testUpdateTopHeader(t *testing.T, stack *Stack) {
+	if _, err := stack.Push([]byte(`{"kind":"a"}`), []byte("body-a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stack.Push([]byte(`{"kind":"b"}`), []byte("body-b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := stack.AddLatestIndex("kind"); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"c", "long"} {
+		if _, err := stack.UpdateTopHeader([]byte(`{"kind":"` + kind + `"}`)); err != nil {
+			t.Fatal(err)
+		}
+		_, data, err := stack.Latest("kind", kind)
+		if err != nil || string(data) != "body-b" {
+			t.Fatal("Unexpected segment for updated header", string(data), err)
+		}
+	}
+	if _, _, err := stack.Latest("kind", "b"); err != ErrNotFound {
+		t.Fatal("Old header must be removed from index, got", err)
+	}
+	if _, err := stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	header, data, err := stack.Pop()
+	if err != nil || string(header) != `{"kind":"long"}` || string(data) != "body-b" {
+		t.Fatal("Unexpected top", string(header), string(data), err)
+	}
+	header, data, err = stack.Pop()
+	if err != nil || string(header) != `{"kind":"a"}` || string(data) != "body-a" {
+		t.Fatal("Unexpected segment", string(header), string(data), err)
+	}
+}
+
+func TestStackUpdateTopHeader(t *testing.T) {
+	stack, err := NewMemoryStack(WithCache(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testUpdateTopHeader(t, stack)
+}
+
+func TestSplitStackUpdateTopHeader(t *testing.T) {
+	stack, err := CreateSplitStack("temp.stack", "temp.stack.blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.blob")
+	defer os.Remove("temp.stack.index.kind")
+	defer stack.Close()
+	testUpdateTopHeader(t, stack)
+}
+
+func TestStackUpdateTopHeaderLarge(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		storage, blob := &countingStorage{MemoryStorage: NewMemoryStorage()}, &countingStorage{MemoryStorage: NewMemoryStorage()}
+		journal := &sizeTrackingStorage{MemoryStorage: NewMemoryStorage()}
+		open := func() (*Stack, error) {
+			if split {
+				return NewSplitStorageStack(storage, blob, WithJournal(journal))
+			}
+			return NewStorageStack(storage, WithJournal(journal))
+		}
+		stack, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, 64*1024)
+		if _, err = stack.Push([]byte(`{"kind":"a"}`), body); err != nil {
+			t.Fatal(err)
+		}
+		// Body is neither read nor saved to journal
+		storage.bytes, blob.bytes, journal.max = 0, 0, 0
+		if _, err = stack.UpdateTopHeader([]byte(`{"kind":"b"}`)); err != nil {
+			t.Fatal(err)
+		}
+		if read := storage.bytes + blob.bytes; journal.max > 1024 || read > 1024 {
+			t.Fatal("Body must not be read or journaled: journal", journal.max, "read", read, "split", split)
+		}
+		if stack, err = open(); err != nil {
+			t.Fatal(err)
+		}
+		report, err := stack.Verify(VerifyOptions{Checksums: true})
+		if err != nil || !report.OK() {
+			t.Fatal("Unexpected report", report, err)
+		}
+		header, data, err := stack.Peak()
+		if err != nil || string(header) != `{"kind":"b"}` || len(data) != len(body) {
+			t.Fatal("Unexpected top", string(header), len(data), err)
+		}
+	}
+}
+
+// Storage which tracks maximal size of written content
+type sizeTrackingStorage struct {
+	*MemoryStorage
+	max int64
+}
+
+func (ss *sizeTrackingStorage) WriteAt(p []byte, offset int64) (int, error) {
+	if end := offset + int64(len(p)); end > ss.max {
+		ss.max = end
+	}
+	return ss.MemoryStorage.WriteAt(p, offset)
+}
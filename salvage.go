@@ -0,0 +1,220 @@
+package fstack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Size of window to search signatures of blocks in damaged region
+const salvageWindow = 64 * 1024
+
+// SalvageRegion - unrecoverable region of source stack
+type SalvageRegion struct {
+	Storage string `json:"storage"` // "file" or "blob"
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+}
+
+// SalvageReport - result of Salvage
+type SalvageReport struct {
+	Recovered   int             `json:"recovered"`   // Number of segments copied to new stack
+	BytesLost   int64           `json:"bytes_lost"`  // Total size of quarantined regions
+	Quarantined []SalvageRegion `json:"quarantined"` // Regions which are not recovered
+}
+
+// Block found by salvage scanner
+type salvaged struct {
+	offset int64
+	block  fileBlock
+	header []byte
+	data   []byte
+}
+
+// Salvage - copy all recoverable segments from src to empty dst keeping push time and sequence
+// numbers. Unlike Repare which drops everything after first damaged block, salvage scanner
+// searches forward for plausible blocks: meta-info which points to header right after it, sizes
+// inside files and in limits of src (see WithMaxSize) and valid commit marker with checksum (if
+// stack has them). Surviving blocks are re-linked in dst, batches are recovered only if all blocks
+// of batch survived. Regions of files which are not recovered are written to quarantine (if not
+// nil): each region is text line "fstack quarantine <storage> offset <offset> length <length>"
+// followed by raw content and new line.
+//
+// Source stack must be opened WithReadOnly: usual open drops damaged tail before salvage
+func Salvage(dst, src *Stack, quarantine io.Writer) (SalvageReport, error) {
+	report := SalvageReport{Quarantined: []SalvageRegion{}}
+	if dst == src {
+		return report, ErrNotEmpty
+	}
+	src.guard.Lock()
+	defer src.guard.Unlock()
+	dst.guard.Lock()
+	defer dst.guard.Unlock()
+	if dst.depth != 0 {
+		return report, ErrNotEmpty
+	}
+	if dst.options.readOnly {
+		return report, ErrReadOnly
+	}
+	src.lastAccess = time.Now()
+	dst.lastAccess = src.lastAccess
+	file, err := src.getFile()
+	if err != nil {
+		return report, err
+	}
+	dataFile, err := src.getData()
+	if err != nil {
+		return report, err
+	}
+	fileSize, err := file.Size()
+	if err != nil {
+		return report, err
+	}
+	dataSize, err := dataFile.Size()
+	if err != nil {
+		return report, err
+	}
+	var (
+		pos      = int64(superBlockSize)
+		fileEnd  = int64(superBlockSize) // End of last recovered block
+		dataEnd  int64                   // End of data of last recovered block in split layout
+		nextSeq  = src.nextSeq
+		pending  []salvaged // Blocks of unfinished batch
+		window   = make([]byte, salvageWindow+fileBlockDefineSize)
+		messages []Message
+	)
+	for pos < fileSize {
+		found, ok, err := src.salvageAt(file, dataFile, pos, fileSize, dataSize, dataEnd)
+		if err != nil {
+			return report, err
+		}
+		if !ok {
+			// Batch is broken by damaged region
+			pending = pending[:0]
+			if pos, err = findSignature(file, window, pos+1, fileSize); err != nil {
+				return report, err
+			}
+			continue
+		}
+		pending = append(pending, found)
+		pos = src.blockEnd(&found.block)
+		if found.block.Batch {
+			continue
+		}
+		first := &pending[0]
+		err = report.quarantine(quarantine, "file", file, fileEnd, first.offset)
+		if err == nil && src.split() {
+			err = report.quarantine(quarantine, "blob", dataFile, dataEnd, int64(first.block.DataPoint))
+		}
+		if err != nil {
+			return report, err
+		}
+		messages = messages[:0]
+		for _, block := range pending {
+			messages = append(messages, Message{Header: block.header, Data: block.data})
+		}
+		err = dst.apply(nil, nil, messages, first.block.Timestamp, first.block.Sequence, false)
+		if err != nil {
+			return report, err
+		}
+		report.Recovered += len(pending)
+		if found.block.Sequence >= nextSeq {
+			nextSeq = found.block.Sequence + 1
+		}
+		fileEnd, dataEnd = pos, found.block.NextBlockPoint()
+		pending = pending[:0]
+	}
+	err = report.quarantine(quarantine, "file", file, fileEnd, fileSize)
+	if err == nil && src.split() {
+		err = report.quarantine(quarantine, "blob", dataFile, dataEnd, dataSize)
+	}
+	if err != nil {
+		return report, err
+	}
+	return report, dst.keepNextSeq(nextSeq)
+}
+
+// Check that plausible block is at offset and read it. Data of block in split layout must not be
+// before minData. Guard must be locked
+func (s *Stack) salvageAt(file, dataFile Storage, offset, fileSize, dataSize, minData int64) (salvaged, bool, error) {
+	found := salvaged{offset: offset}
+	block, err := readBlockAt(file, offset)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return found, false, nil
+	}
+	if err != nil {
+		return found, false, err
+	}
+	// Sizes are checked separately against overflow
+	if int64(block.HeaderPoint) != offset+fileBlockDefineSize || int64(block.PrevBlock) >= offset ||
+		block.HeaderSize > uint64(fileSize) || block.DataSize > uint64(dataSize) ||
+		block.DataPoint > uint64(dataSize) || s.blockEnd(&block) > fileSize || block.NextBlockPoint() > dataSize {
+		return found, false, nil
+	}
+	if s.split() && int64(block.DataPoint) < minData {
+		return found, false, nil
+	}
+	if !s.split() && block.DataPoint != block.HeaderPoint+block.HeaderSize {
+		return found, false, nil
+	}
+	// Damaged sizes inside large file must not cause huge allocations
+	if s.checkLimits(&block, true) != nil {
+		return found, false, nil
+	}
+	found.header, found.data, err = s.readBlockInto(&block, nil, nil)
+	if err != nil {
+		return found, false, err
+	}
+	if s.checksums() {
+		crc, ok, err := s.readTrailer(file, &block)
+		if err != nil || !ok || blockChecksum(&block, found.header, found.data) != crc {
+			return found, false, err
+		}
+	}
+	found.block = block
+	return found, true, nil
+}
+
+// Find next offset starting from pos where meta-info of block may be: header point of meta-info
+// points right after it. Returns fileSize if nothing found
+func findSignature(file Storage, window []byte, pos, fileSize int64) (int64, error) {
+	for pos < fileSize {
+		n, err := file.ReadAt(window, pos)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := 0; i+fileBlockDefineSize <= n; i++ {
+			if binary.LittleEndian.Uint64(window[i+8:]) == uint64(pos+int64(i)+fileBlockDefineSize) {
+				return pos + int64(i), nil
+			}
+		}
+		if n < len(window) {
+			break
+		}
+		pos += int64(n - fileBlockDefineSize + 1)
+	}
+	return fileSize, nil
+}
+
+// Add region to report and copy it to quarantine
+func (sr *SalvageReport) quarantine(quarantine io.Writer, name string, storage Storage, from, to int64) error {
+	if from >= to {
+		return nil
+	}
+	sr.Quarantined = append(sr.Quarantined, SalvageRegion{Storage: name, Offset: from, Length: to - from})
+	sr.BytesLost += to - from
+	if quarantine == nil {
+		return nil
+	}
+	_, err := fmt.Fprintf(quarantine, "fstack quarantine %s offset %d length %d\n", name, from, to-from)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(quarantine, io.NewSectionReader(storage, from, to-from))
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(quarantine, "\n")
+	return err
+}
@@ -0,0 +1,166 @@
+package fstack
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Push segments 0..n-1 one by one
+func pushTestSegments(t *testing.T, stack *Stack, n int) {
+	for _, message := range testBatch(n) {
+		if _, err := stack.Push(message.Header, message.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Check content of stack: segments with numbers from bottom to top
+func checkSalvaged(t *testing.T, stack *Stack, numbers ...int) {
+	if stack.Depth() != len(numbers) {
+		t.Fatal("Unexpected depth", stack.Depth(), "!=", len(numbers))
+	}
+	for i := len(numbers) - 1; i >= 0; i-- {
+		seq := stack.TopSeq()
+		header, data, err := stack.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(header) != fmt.Sprint("header-", numbers[i]) || string(data) != fmt.Sprint("body-", numbers[i]) ||
+			seq != uint64(numbers[i]+1) {
+			t.Fatal("Unexpected segment", string(header), string(data), seq)
+		}
+	}
+}
+
+func TestStackSalvage(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestSegments(t, stack, 5)
+	damaged, next := stack.offsets[2], stack.offsets[3]
+	// Damage meta-info of block in the middle
+	if _, err = storage.WriteAt([]byte("garbage"), damaged+4); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewStorageStack(storage, WithReadOnly(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Depth() != 2 {
+		t.Fatal("Unexpected depth of source", src.Depth())
+	}
+	dst, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var quarantine bytes.Buffer
+	report, err := Salvage(dst, src, &quarantine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Recovered != 4 || report.BytesLost != next-damaged || len(report.Quarantined) != 1 ||
+		report.Quarantined[0] != (SalvageRegion{Storage: "file", Offset: damaged, Length: next - damaged}) {
+		t.Fatal("Unexpected report", report)
+	}
+	prefix := fmt.Sprintf("fstack quarantine file offset %d length %d\n", damaged, next-damaged)
+	if !strings.HasPrefix(quarantine.String(), prefix) || quarantine.Len() != len(prefix)+int(next-damaged)+1 {
+		t.Fatal("Unexpected quarantine", quarantine.String())
+	}
+	// Sequence numbers of lost segments are not reused
+	if seq, err := dst.Push([]byte("header-5"), []byte("body-5")); err != nil || seq != 6 {
+		t.Fatal(seq, err)
+	}
+	checkSalvaged(t, dst, 0, 1, 3, 4, 5)
+}
+
+func TestSplitStackSalvage(t *testing.T) {
+	storage, blob := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewSplitStorageStack(storage, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestSegments(t, stack, 3)
+	// Batch of two segments: second one is damaged
+	if _, err = stack.PushBatch([]Message{{[]byte("header-3"), []byte("body-3")}, {[]byte("header-4"), []byte("body-4")}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-5"), []byte("body-5")); err != nil {
+		t.Fatal(err)
+	}
+	top, err := readBlockAt(storage, stack.offsets[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damage body of first segment and of segment in batch
+	first, err := readBlockAt(storage, stack.offsets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []fileBlock{first, top} {
+		if _, err = blob.WriteAt([]byte("X"), int64(block.DataPoint)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := NewSplitStorageStack(storage, blob, WithReadOnly(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Salvage(dst, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SalvageRegion{
+		{Storage: "file", Offset: stack.offsets[0], Length: stack.offsets[1] - stack.offsets[0]},
+		{Storage: "blob", Offset: 0, Length: 6},
+		{Storage: "file", Offset: stack.offsets[3], Length: stack.offsets[5] - stack.offsets[3]},
+		{Storage: "blob", Offset: 18, Length: 12},
+	}
+	if report.Recovered != 3 || report.BytesLost != expected[0].Length+expected[2].Length+18 ||
+		fmt.Sprint(report.Quarantined) != fmt.Sprint(expected) {
+		t.Fatal("Unexpected report", report)
+	}
+	checkSalvaged(t, dst, 1, 2, 5)
+}
+
+func TestStackSalvageMaxSize(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTestSegments(t, stack, 3)
+	if _, err = stack.Push([]byte("header-3"), make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header-4"), []byte("body-4")); err != nil {
+		t.Fatal(err)
+	}
+	// Damage meta-info before segment over limits, so open does not reach it
+	if _, err = storage.WriteAt([]byte("garbage"), stack.offsets[2]+4); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewStorageStack(storage, WithReadOnly(), WithMaxSize(0, 16), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Salvage(dst, src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Recovered != 3 || len(report.Quarantined) != 1 || report.BytesLost != stack.offsets[4]-stack.offsets[2] {
+		t.Fatal("Segment over limits must not be salvaged", report)
+	}
+	checkSalvaged(t, dst, 0, 1, 4)
+}
@@ -0,0 +1,97 @@
+package fstack
+
+import (
+	"io"
+	"time"
+)
+
+// Size of read-ahead buffer for headers scan
+const scanBufferSize = 1024 * 1024
+
+// Sequential reader with big buffer. Small gaps (bodies) are read as part of
+// buffer, big gaps are skipped without reading
+type readAhead struct {
+	src   io.ReaderAt
+	buf   []byte
+	start int64 // Offset of first byte in buffer
+	limit int64 // Offset of end of readable area
+}
+
+func newReadAhead(src io.ReaderAt, limit int64, size int) *readAhead {
+	return &readAhead{src: src, buf: make([]byte, 0, size), limit: limit}
+}
+
+// Get n bytes at offset. Returned slice is valid till next call
+func (r *readAhead) get(offset int64, n int) ([]byte, error) {
+	// Range is checked before buffer is grown
+	if n < 0 || offset < 0 || offset > r.limit || int64(n) > r.limit-offset {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if offset >= r.start && offset+int64(n) <= r.start+int64(len(r.buf)) {
+		return r.buf[offset-r.start:][:n], nil
+	}
+	if n > cap(r.buf) {
+		r.buf = make([]byte, 0, n)
+	}
+	size := int64(cap(r.buf))
+	if offset+size > r.limit {
+		size = r.limit - offset
+	}
+	if size < int64(n) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r.buf = r.buf[:size]
+	r.start = offset
+	_, err := r.src.ReadAt(r.buf, offset)
+	if err != nil {
+		r.buf = r.buf[:0]
+		return nil, err
+	}
+	return r.buf[:n], nil
+}
+
+// ScanHeaders - iterate over headers of all segments from bottom to top without reading bodies.
+// Meta-info and headers are read by big sequential chunks. Header slice is reused and valid
+// only inside handler. Return false from handler to stop scan
+func (s *Stack) ScanHeaders(handler func(depth int, seq uint64, header []byte) bool) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	s.lastAccess = time.Now()
+	return s.scan(func(depth int, block *fileBlock, header []byte) bool {
+		return handler(depth, block.Sequence, header)
+	})
+}
+
+// Scan meta-info and headers of all segments. Guard must be locked
+func (s *Stack) scan(handler func(depth int, block *fileBlock, header []byte) bool) error {
+	if s.depth == 0 {
+		return nil
+	}
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	reader := newReadAhead(file, s.nextBlockPoint(), scanBufferSize)
+	var block fileBlock
+	for depth, offset := range s.offsets {
+		meta, err := reader.get(offset, fileBlockDefineSize)
+		if err != nil {
+			return corruptAt(offset, err)
+		}
+		block.decode(meta)
+		if err = s.checkBlock(&block, false); err != nil {
+			return err
+		}
+		header, err := reader.get(int64(block.HeaderPoint), int(block.HeaderSize))
+		if err != nil {
+			return corruptAt(offset, err)
+		}
+		if !handler(depth, &block, header) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package fstack
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStackScanHeaders(t *testing.T) {
+	N := 20
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	for i := 0; i < N; i++ {
+		body := []byte("small")
+		if i%5 == 0 {
+			// Bigger than read-ahead buffer
+			body = bytes.Repeat([]byte{'x'}, scanBufferSize+i)
+		}
+		if _, err := stack.Push([]byte(fmt.Sprint("header-", i)), body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	err = stack.ScanHeaders(func(depth int, seq uint64, header []byte) bool {
+		if depth != count {
+			t.Fatal("Unexpected depth", depth, "expected", count)
+		}
+		if seq != uint64(count+1) {
+			t.Fatal("Unexpected sequence number", seq)
+		}
+		if string(header) != fmt.Sprint("header-", count) {
+			t.Fatal("Unexpected header", string(header))
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != N {
+		t.Fatal("Not all headers scanned:", count)
+	}
+}
+
+func BenchmarkStackScanHeaders(b *testing.B) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stack.Close()
+	for i := 0; i < 10000; i++ {
+		if _, err := stack.Push([]byte("112233"), []byte("AAABBBCCC")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := stack.ScanHeaders(func(depth int, seq uint64, header []byte) bool { return true })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
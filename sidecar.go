@@ -0,0 +1,149 @@
+package fstack
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+)
+
+// Index over segments of stack, maintained on every change. Guard is locked during calls
+type index interface {
+	// Segment pushed (stack state already updated)
+	pushed(s *Stack, offset int64, block *fileBlock, header []byte)
+	// Segment popped (stack state already updated)
+	popped(s *Stack, offset int64, block *fileBlock, header []byte)
+	// Rebuild index from scratch
+	rebuild(s *Stack) error
+	// Persist index to sidecar file
+	save(s *Stack) error
+}
+
+// Notify indexes about pushed segment. Guard must be locked
+func (s *Stack) indexPushed(offset int64, block *fileBlock, header []byte) {
+	for _, idx := range s.indexes {
+		idx.pushed(s, offset, block, header)
+	}
+}
+
+// Notify indexes about popped segment. Guard must be locked
+func (s *Stack) indexPopped(offset int64, block *fileBlock, header []byte) {
+	for _, idx := range s.indexes {
+		idx.popped(s, offset, block, header)
+	}
+}
+
+// Rebuild all indexes. Guard must be locked
+func (s *Stack) rebuildIndexes() error {
+	for _, idx := range s.indexes {
+		if err := idx.rebuild(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save all indexes to sidecar files. Guard must be locked
+func (s *Stack) saveIndexes() error {
+	var err error
+	for _, idx := range s.indexes {
+		if saveErr := idx.save(s); saveErr != nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// State of stack for which sidecar file is valid. Sequence numbers are never reused in file and
+// recreated file gets new identity, so same file, top sequence number and depth means same content
+type sidecarStamp struct {
+	File  uint64
+	Depth int
+	Seq   uint64
+}
+
+func (s *Stack) stamp() sidecarStamp {
+	return sidecarStamp{File: s.super.FileID, Depth: s.depth, Seq: s.currentBlock.Sequence}
+}
+
+// Content of sidecar file
+type sidecarContent struct {
+	Stamp sidecarStamp
+	Data  interface{}
+}
+
+// Sidecar file persists index of stack (kind) for header field between restarts. Sidecar is
+// removed on first change of stack after load or save, so crash leads to rebuild, not to stale index
+type sidecar struct {
+	fileName string // Empty if stack has no file name
+	valid    bool   // File on disk corresponds to current stack state
+	logger   Logger
+	readOnly bool // File is never written or removed
+}
+
+func newSidecar(s *Stack, kind, field string) sidecar {
+	if s.fileName == "" {
+		return sidecar{}
+	}
+	return sidecar{fileName: s.fileName + "." + kind + "." + url.QueryEscape(field), logger: s.logger(),
+		readOnly: s.options.readOnly}
+}
+
+// Load content of sidecar file if it's valid for current stack state
+func (sc *sidecar) load(s *Stack, data interface{}) bool {
+	if sc.fileName == "" {
+		return false
+	}
+	file, err := os.Open(sc.fileName)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	content := sidecarContent{Data: data}
+	err = json.NewDecoder(file).Decode(&content)
+	if err != nil {
+		s.logger().Println("Broken sidecar file", sc.fileName, ":", err)
+		return false
+	}
+	sc.valid = content.Stamp == s.stamp()
+	return sc.valid
+}
+
+// Atomically save content to sidecar file
+func (sc *sidecar) save(s *Stack, data interface{}) error {
+	if sc.fileName == "" || sc.valid || sc.readOnly {
+		return nil
+	}
+	tmpName := sc.fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(file).Encode(sidecarContent{Stamp: s.stamp(), Data: data})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	err = os.Rename(tmpName, sc.fileName)
+	if err != nil {
+		return err
+	}
+	sc.valid = true
+	return nil
+}
+
+// Mark sidecar file as outdated
+func (sc *sidecar) invalidate() {
+	if !sc.valid {
+		return
+	}
+	sc.valid = false
+	if sc.readOnly {
+		return
+	}
+	if err := os.Remove(sc.fileName); err != nil && !os.IsNotExist(err) {
+		sc.logger.Println("Can't remove outdated sidecar file", sc.fileName, ":", err)
+	}
+}
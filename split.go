@@ -0,0 +1,114 @@
+package fstack
+
+import (
+	"os"
+	"time"
+)
+
+// OpenSplitStack - open or create stack in split layout: meta-info and headers are stored in
+// compact file, bodies - in separate blob file. Scan of headers in this layout does not touch bodies
+func OpenSplitStack(filename, blobFilename string, options ...Option) (*Stack, error) {
+	mode := openMode(readOnly(options))
+	file, err := openLocked(filename, mode)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := openLocked(blobFilename, mode)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return NewSplitStack(file, blob, options...)
+}
+
+// CreateSplitStack - create or truncate stack in split layout
+func CreateSplitStack(filename, blobFilename string, options ...Option) (*Stack, error) {
+	if readOnly(options) {
+		return nil, ErrReadOnly
+	}
+	file, err := openLocked(filename, os.O_TRUNC|os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := openLocked(blobFilename, os.O_TRUNC|os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return NewSplitStack(file, blob, options...)
+}
+
+// NewSplitStack - create new stack in split layout based on meta-info file and blob file. Files
+// are locked as in NewStack
+func NewSplitStack(file, blob *os.File, options ...Option) (*Stack, error) {
+	err := lockFile(file, readOnly(options))
+	if err == nil {
+		err = lockFile(blob, readOnly(options))
+	}
+	if err != nil {
+		file.Close()
+		blob.Close()
+		return nil, err
+	}
+	return newStack(NewFileStorage(file), NewFileStorage(blob), file.Name(), blob.Name(), options)
+}
+
+// Convert - copy all segments from src stack to empty dst stack keeping push time and sequence
+// numbers. Layouts of stacks may be different, so it's a converter between interleaved and split
+// layouts
+func Convert(dst, src *Stack) error {
+	if dst == src {
+		return ErrNotEmpty
+	}
+	src.guard.Lock()
+	defer src.guard.Unlock()
+	dst.guard.Lock()
+	defer dst.guard.Unlock()
+	if dst.depth != 0 {
+		return ErrNotEmpty
+	}
+	if dst.options.readOnly {
+		return ErrReadOnly
+	}
+	src.lastAccess = time.Now()
+	dst.lastAccess = src.lastAccess
+	file, err := src.getFile()
+	if err != nil {
+		return err
+	}
+	for _, offset := range src.offsets {
+		block, err := src.blockAt(file, offset)
+		if err != nil {
+			return err
+		}
+		header, data, err := src.readBlock(&block)
+		if err != nil {
+			return err
+		}
+		err = dst.push(header, data, block.Timestamp, block.Sequence)
+		if err != nil {
+			return err
+		}
+	}
+	return dst.keepNextSeq(src.nextSeq)
+}
+
+// Keep high-water mark of sequence numbers of source stack. Guard must be locked
+func (s *Stack) keepNextSeq(nextSeq uint64) error {
+	if s.super.NextSequence >= nextSeq {
+		return nil
+	}
+	s.super.NextSequence = nextSeq
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	err = s.super.writeTo(file)
+	if err != nil {
+		return err
+	}
+	if s.nextSeq < nextSeq {
+		s.nextSeq = nextSeq
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package fstack
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSplitStack(t *testing.T) {
+	N := 10
+	stack, err := CreateSplitStack("temp.stack", "temp.stack.blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("temp.stack.blob")
+	for i := 0; i < N; i++ {
+		if _, err := stack.Push([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	header, data, err := stack.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != fmt.Sprint("header-", N-1) || string(data) != fmt.Sprint("body-", N-1) {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	stack.Close()
+	if _, err = OpenStack("temp.stack"); err != ErrLayout {
+		t.Fatal("Split stack must not be opened as interleaved, got", err)
+	}
+	stack, err = OpenSplitStack("temp.stack", "temp.stack.blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if stack.Depth() != N-1 {
+		t.Fatal("Unexpected depth after reopen", stack.Depth())
+	}
+	var i int
+	err = stack.IterateForward(func(depth int, header io.Reader, body io.Reader) bool {
+		h, _ := ioutil.ReadAll(header)
+		b, _ := ioutil.ReadAll(body)
+		if string(h) != fmt.Sprint("header-", i) || string(b) != fmt.Sprint("body-", i) {
+			t.Fatal("Unexpected segment", string(h), string(b))
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != N-1 {
+		t.Fatal("Not all segments iterated:", i)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	src, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := src.Push([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = src.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := NewSplitStorageStack(NewMemoryStorage(), NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err = Convert(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Depth() != src.Depth() {
+		t.Fatal("Depth of converted stack", dst.Depth(), "!=", src.Depth())
+	}
+	header, data, err := dst.GetBySeq(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "header-1" || string(data) != "body-1" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	seq, err := dst.Push(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != 6 {
+		t.Fatal("Sequence high-water mark is not converted, got", seq)
+	}
+}
@@ -2,8 +2,8 @@ package fstack
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
-	"log"
 	"os"
 	"sync"
 	"time"
@@ -16,9 +16,29 @@ type Stack struct {
 	currentBlock    fileBlock
 	currentBlockPos int64
 	guard           sync.Mutex
-	file            *os.File
-	fileName        string
+	file            Storage
+	fileName        string      // Name of file for reopen. Empty for custom storage
+	blob            Storage     // Bodies of segments in split layout
+	blobName        string      // Name of file with bodies for reopen
+	closed          bool        // Stack closed and can't be reopened
+	fileInfo        os.FileInfo // Identity of released files for check on reopen
+	blobInfo        os.FileInfo
+	idleTimer       *time.Timer // Release of idle handles (see WithIdleTimeout)
+	options         options
+	headerBuf       []byte // Reusable buffers for reads without allocations
+	dataBuf         []byte
+	writeBuf        []byte // Reusable buffer for blocks written by push
 	lastAccess      time.Time
+	offsets         []int64 // Positional index: offsets of blocks from bottom to top
+	super           superBlock
+	nextSeq         uint64 // Sequence number for next pushed block
+	indexes         []index
+	valueIndexes    map[string]*valueIndex // Secondary indexes by field
+	cache           *topCache              // Newest segments in memory (see WithCache)
+	journal         Storage                // Undo journal of transactions
+	repair          RepairReport           // Report of last repair
+	fileView        *journalRegion         // Rollback of interrupted operation in read-only mode
+	blobView        *journalRegion
 }
 
 // Meta-info before each physical block on fs
@@ -28,181 +48,319 @@ type fileBlock struct {
 	HeaderSize  uint64 // Size in byte of header
 	DataPoint   uint64 // Location of data begining of block
 	DataSize    uint64 // Size in byte of data
+	Timestamp   int64  // Push time in Unix nanoseconds. Never less then timestamp of previous block
+	Sequence    uint64 // Unique (in file) and monotonic number of block. Starts from 1
+	Batch       bool   // Block is not last in batch. Stored as highest bit of sequence number
 }
 
+// Flag of block in sequence number field: batch is not finished by this block
+const blockBatchOpen = 1 << 63
+
 // Read meta-info at specified place
-func readBlockAt(reader io.ReadSeeker, offset int64) (fileBlock, error) {
+func readBlockAt(reader io.ReaderAt, offset int64) (fileBlock, error) {
 	var block fileBlock
-	_, err := reader.Seek(offset, os.SEEK_SET)
+	var buf [fileBlockDefineSize]byte
+	n, err := reader.ReadAt(buf[:], offset)
+	if n == len(buf) {
+		err = nil
+	} else if n > 0 && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
 	if err != nil {
 		return block, err
 	}
-	return block, binary.Read(reader, binary.LittleEndian, &block)
+	block.decode(buf[:])
+	return block, nil
 }
 
 // Write meta-info to specified place
-func (fb *fileBlock) writeTo(writer io.WriteSeeker, offset int64) error {
-	_, err := writer.Seek(offset, os.SEEK_SET)
-	if err != nil {
-		return err
-	}
-	return binary.Write(writer, binary.LittleEndian, *fb)
+func (fb *fileBlock) writeTo(writer io.WriterAt, offset int64) error {
+	var buf [fileBlockDefineSize]byte
+	fb.encode(buf[:])
+	_, err := writer.WriteAt(buf[:], offset)
+	return err
+}
+
+// Decode meta-info from buffer without allocations
+func (fb *fileBlock) decode(buf []byte) {
+	fb.PrevBlock = binary.LittleEndian.Uint64(buf[0:])
+	fb.HeaderPoint = binary.LittleEndian.Uint64(buf[8:])
+	fb.HeaderSize = binary.LittleEndian.Uint64(buf[16:])
+	fb.DataPoint = binary.LittleEndian.Uint64(buf[24:])
+	fb.DataSize = binary.LittleEndian.Uint64(buf[32:])
+	fb.Timestamp = int64(binary.LittleEndian.Uint64(buf[40:]))
+	fb.Sequence = binary.LittleEndian.Uint64(buf[48:])
+	fb.Batch = fb.Sequence&blockBatchOpen != 0
+	fb.Sequence &^= blockBatchOpen
 }
 
+// Encode meta-info to buffer
+func (fb *fileBlock) encode(buf []byte) {
+	binary.LittleEndian.PutUint64(buf[0:], fb.PrevBlock)
+	binary.LittleEndian.PutUint64(buf[8:], fb.HeaderPoint)
+	binary.LittleEndian.PutUint64(buf[16:], fb.HeaderSize)
+	binary.LittleEndian.PutUint64(buf[24:], fb.DataPoint)
+	binary.LittleEndian.PutUint64(buf[32:], fb.DataSize)
+	binary.LittleEndian.PutUint64(buf[40:], uint64(fb.Timestamp))
+	seq := fb.Sequence
+	if fb.Batch {
+		seq |= blockBatchOpen
+	}
+	binary.LittleEndian.PutUint64(buf[48:], seq)
+}
+
+// Offset of block in file: meta-info is followed by header
+func (fb *fileBlock) offset() int64 { return int64(fb.HeaderPoint) - fileBlockDefineSize }
+
 // Calculate next block position
 func (fb *fileBlock) NextBlockPoint() int64 { return int64(fb.DataPoint + fb.DataSize) }
 
-const fileBlockDefineSize = 8 + 8 + 8 + 8 + 8
+// Time of push
+func (fb *fileBlock) Time() time.Time { return time.Unix(0, fb.Timestamp) }
+
+const fileBlockDefineSize = 8 + 8 + 8 + 8 + 8 + 8 + 8
 
-// Push header and body to stack. Returns new value of stack depth
-func (s *Stack) Push(header, data []byte) (depth int, err error) {
+// Push header and body to stack. Returns sequence number of new segment. Sequence numbers
+// are never reused in the same file even after Pop
+func (s *Stack) Push(header, data []byte) (seq uint64, err error) {
 	s.guard.Lock()
 	defer s.guard.Unlock()
+	if err = s.checkOpen(); err != nil {
+		return 0, err
+	}
+	seq = s.nextSeq
+	return seq, s.push(header, data, s.pushTimestamp(), seq)
+}
+
+// Update last access time and get timestamp for new block. Guard must be locked
+func (s *Stack) pushTimestamp() int64 {
 	s.lastAccess = time.Now()
-	file, err := s.getFile()
-	if err != nil {
-		return -1, err
+	timestamp := s.lastAccess.UnixNano()
+	if timestamp < s.currentBlock.Timestamp {
+		// Clock goes backward - keep order of timestamps for binary search
+		timestamp = s.currentBlock.Timestamp
 	}
-	// Seek to place for next block
-	currentOffset, err := file.Seek(s.currentBlock.NextBlockPoint(), os.SEEK_SET)
-	if err != nil {
-		return -1, err
-	}
-	// Get place for payload
-	bodyOffset := currentOffset + fileBlockDefineSize
-	block := fileBlock{
-		PrevBlock:   uint64(s.currentBlockPos),
-		HeaderPoint: uint64(bodyOffset),
-		HeaderSize:  uint64(len(header)),
-		DataPoint:   uint64(bodyOffset) + uint64(len(header)),
-		DataSize:    uint64(len(data)),
-	}
-	// Write block meta-info
-	err = binary.Write(file, binary.LittleEndian, block)
-	if err != nil {
-		file.Seek(currentOffset, os.SEEK_SET)
-		return -1, err
+	return timestamp
+}
+
+// Position for next block
+func (s *Stack) nextBlockPoint() int64 {
+	if s.depth == 0 {
+		return superBlockSize
 	}
-	// Write header
-	_, err = file.Write(header)
-	if err != nil {
-		file.Seek(currentOffset, os.SEEK_SET)
-		return -1, err
+	return s.blockEnd(&s.currentBlock)
+}
+
+// Position for data of next block
+func (s *Stack) nextDataPoint() int64 {
+	if !s.split() {
+		return s.nextBlockPoint() // not used: data follows header
 	}
-	// Write data
-	_, err = file.Write(data)
-	if err != nil {
-		file.Seek(currentOffset, os.SEEK_SET)
-		return -1, err
+	return int64(s.currentBlock.DataPoint + s.currentBlock.DataSize)
+}
+
+// End of block (with commit marker) in file with meta-info
+func (s *Stack) blockEnd(fb *fileBlock) int64 {
+	if s.split() {
+		return int64(fb.HeaderPoint+fb.HeaderSize) + s.trailerSize()
 	}
-	s.depth++
-	s.currentBlockPos = currentOffset
-	s.currentBlock = block
-	return s.depth, nil
+	return fb.NextBlockPoint() + s.trailerSize()
+}
+
+// Is stack in split layout: headers and bodies in different files
+func (s *Stack) split() bool { return s.super.Flags&flagSplit != 0 }
+
+// Push block with specified timestamp and sequence number. Guard must be locked
+func (s *Stack) push(header, data []byte, timestamp int64, seq uint64) error {
+	return s.apply(nil, nil, []Message{{Header: header, Data: data}}, timestamp, seq, s.options.sync)
 }
 
-// Pop one segment from tail of stack. Returns nil,nil,nil if depth is 0
+// Pop one segment from tail of stack. Returns ErrEmpty if depth is 0
 func (s *Stack) Pop() (header, data []byte, err error) {
-	if s.depth == 0 {
-		return nil, nil, nil
-	}
 	s.guard.Lock()
 	defer s.guard.Unlock()
-	s.lastAccess = time.Now()
-	file, err := s.getFile()
-	if err != nil {
+	if err = s.checkOpen(); err != nil {
 		return nil, nil, err
 	}
-	data = make([]byte, s.currentBlock.DataSize)
-	header = make([]byte, s.currentBlock.HeaderSize)
-	// Read header
-	_, err = file.ReadAt(header, int64(s.currentBlock.HeaderPoint))
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	return s.pop()
+}
+
+// Read and remove top segment. Guard must be locked
+func (s *Stack) pop() (header, data []byte, err error) {
+	if cached, ok := s.cachedTop(); ok {
+		header, data = cached.header, cached.data
+	} else {
+		header, data, err = s.readBlock(&s.currentBlock)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err = s.removeTop(header)
 	if err != nil {
 		return nil, nil, err
 	}
-	// Read data
-	_, err = file.ReadAt(data, int64(s.currentBlock.DataPoint))
+	return header, data, nil
+}
+
+// Remove top segment with specified header (used for indexes). Guard must be locked
+func (s *Stack) removeTop(header []byte) error {
+	return s.apply([]fileBlock{s.currentBlock}, [][]byte{header}, nil, 0, 0, false)
+}
+
+// Remove top segments by one truncate. Blocks and headers (used for indexes) are from top to
+// bottom. Guard must be locked
+func (s *Stack) removeTopN(blocks []fileBlock, headers [][]byte) error {
+	file, err := s.getFile()
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	// Read new block if current block is not head
+	bottom := &blocks[len(blocks)-1]
+	bottomPos := s.offsets[len(s.offsets)-len(blocks)]
+	// Read new block if last removed block is not head
 	var newBlock fileBlock
-	if s.currentBlockPos != 0 {
-		newBlock, err = readBlockAt(file, int64(s.currentBlock.PrevBlock))
+	if bottom.PrevBlock != 0 {
+		newBlock, err = s.blockAt(file, int64(bottom.PrevBlock))
 		if err != nil {
-			return nil, nil, err
+			return err
+		}
+		if newBlock.Batch {
+			// Part of batch is removed: rest of batch must stay visible
+			newBlock.Batch = false
+			err = newBlock.writeTo(file, int64(bottom.PrevBlock))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// Keep high-water mark of sequence numbers before block removing
+	if s.super.NextSequence < s.currentBlock.Sequence+1 {
+		s.super.NextSequence = s.currentBlock.Sequence + 1
+		err = s.super.writeTo(file)
+		if err != nil {
+			return err
 		}
 	}
 	// Remove tail
-	err = file.Truncate(int64(s.currentBlockPos))
+	err = file.Truncate(bottomPos)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	s.depth--
-	s.currentBlockPos = int64(s.currentBlock.PrevBlock)
-	s.currentBlock = newBlock
+	if s.split() {
+		blob, err := s.getBlob()
+		if err != nil {
+			return err
+		}
+		err = blob.Truncate(int64(bottom.DataPoint))
+		if err != nil {
+			return err
+		}
+	}
+	for i := range blocks {
+		if i+1 < len(blocks) {
+			s.popped(&blocks[i], headers[i], blocks[i+1])
+		} else {
+			s.popped(&blocks[i], headers[i], newBlock)
+		}
+	}
+	return nil
+}
 
-	return header, data, nil
+// Update state after block was written on top. Guard must be locked
+func (s *Stack) pushed(offset int64, block *fileBlock, header, data []byte) {
+	s.depth++
+	s.currentBlockPos = offset
+	s.currentBlock = *block
+	s.offsets = append(s.offsets, offset)
+	s.indexPushed(offset, block, header)
+	s.cachePushed(offset, header, data)
+}
+
+// Update state after top block was removed, so newTop is on top. Guard must be locked
+func (s *Stack) popped(block *fileBlock, header []byte, newTop fileBlock) {
+	poppedPos := s.currentBlockPos
+	s.depth--
+	s.currentBlockPos = int64(block.PrevBlock)
+	s.currentBlock = newTop
+	s.offsets = s.offsets[:len(s.offsets)-1]
+	s.indexPopped(poppedPos, block, header)
+	s.cachePopped(poppedPos)
 }
 
-// Peak of stack - get one segment from stack but not remove
+// Peak of stack - get one segment from stack but not remove. Returns ErrEmpty if depth is 0
 func (s *Stack) Peak() (header, data []byte, err error) {
-	if s.depth == 0 {
-		return nil, nil, nil
-	}
 	s.guard.Lock()
 	defer s.guard.Unlock()
-	s.lastAccess = time.Now()
-	file, err := s.getFile()
-	if err != nil {
+	if err = s.checkOpen(); err != nil {
 		return nil, nil, err
 	}
-	data = make([]byte, s.currentBlock.DataSize)
-	header = make([]byte, s.currentBlock.HeaderSize)
-	// Read header
-	_, err = file.ReadAt(header, int64(s.currentBlock.HeaderPoint))
-	if err != nil {
-		return nil, nil, err
+	if s.depth == 0 {
+		return nil, nil, ErrEmpty
 	}
-	// Read data
-	_, err = file.ReadAt(data, int64(s.currentBlock.DataPoint))
-	if err != nil {
+	s.lastAccess = time.Now()
+	if cached, ok := s.cachedTop(); ok {
+		header = append([]byte(nil), cached.header...)
+		data = append([]byte(nil), cached.data...)
+		return header, data, nil
+	}
+	return s.readBlock(&s.currentBlock)
+}
+
+// Read header and data of block. Block must be inside stack and sizes of block must be in limits
+// (see WithMaxSize). Guard must be locked
+func (s *Stack) readBlock(block *fileBlock) (header, data []byte, err error) {
+	if err = s.checkBlock(block, true); err != nil {
 		return nil, nil, err
 	}
-	return header, data, nil
+	return s.readBlockInto(block, nil, nil)
 }
 
-// PeakHeader get only header part from tail segment from stack without remove
+// PeakHeader get only header part from tail segment from stack without remove. Returns ErrEmpty
+// if depth is 0
 func (s *Stack) PeakHeader() (header []byte, err error) {
-	if s.depth == 0 {
-		return nil, nil
-	}
 	s.guard.Lock()
 	defer s.guard.Unlock()
-	s.lastAccess = time.Now()
-	file, err := s.getFile()
-	if err != nil {
+	if err = s.checkOpen(); err != nil {
 		return nil, err
 	}
-	header = make([]byte, s.currentBlock.HeaderSize)
-	// Read header
-	_, err = file.ReadAt(header, int64(s.currentBlock.HeaderPoint))
+	if s.depth == 0 {
+		return nil, ErrEmpty
+	}
+	s.lastAccess = time.Now()
+	if cached, ok := s.cachedTop(); ok {
+		return append([]byte(nil), cached.header...), nil
+	}
+	file, err := s.getFile()
 	if err != nil {
 		return nil, err
 	}
-	return header, nil
+	return s.readHeader(file, &s.currentBlock)
 }
 
 // Depth of stack - count of segments
-func (s *Stack) Depth() int { return s.depth }
+func (s *Stack) Depth() int {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return s.depth
+}
 
 // LastAccess - time point of last access to stack
-func (s *Stack) LastAccess() time.Time { return s.lastAccess }
+func (s *Stack) LastAccess() time.Time {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return s.lastAccess
+}
 
 // IterateBackward - iterate over hole stack segment-by-segment from end to begining
 func (s *Stack) IterateBackward(handler func(depth int, header io.Reader, body io.Reader) bool) error {
 	s.guard.Lock()
 	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
 	if s.depth == 0 {
 		return nil
 	}
@@ -210,7 +368,10 @@ func (s *Stack) IterateBackward(handler func(depth int, header io.Reader, body i
 	if err != nil {
 		return err
 	}
-	defer file.Seek(0, os.SEEK_END)
+	dataFile, err := s.getData()
+	if err != nil {
+		return err
+	}
 	var (
 		currentBlock       fileBlock // Current block description
 		currentBlockOffset uint64    // Current block offset from begining of file
@@ -219,29 +380,29 @@ func (s *Stack) IterateBackward(handler func(depth int, header io.Reader, body i
 	currentBlockOffset = uint64(s.currentBlockPos)
 	depth := s.depth
 	for {
-		body := io.NewSectionReader(file, int64(currentBlock.DataPoint), int64(currentBlock.DataSize))
+		body := io.NewSectionReader(dataFile, int64(currentBlock.DataPoint), int64(currentBlock.DataSize))
 		header := io.NewSectionReader(file, int64(currentBlock.HeaderPoint), int64(currentBlock.HeaderSize))
 		// invoke block processor
 		if handler != nil && !handler(depth, header, body) {
 			return nil
 		}
-		if currentBlock.PrevBlock > currentBlockOffset {
-			log.Printf("Danger back-ref link: prev block %v has greater index then current %v", currentBlock.PrevBlock, currentBlockOffset)
+		if currentBlock.PrevBlock >= currentBlockOffset {
+			return &CorruptError{Offset: int64(currentBlockOffset), Reason: "back reference to following block"}
 		}
 
 		depth--
-		if currentBlock.PrevBlock == currentBlockOffset {
+		if currentBlock.PrevBlock == 0 {
 			// First block has prev block = 0
 			break
 		}
 		currentBlockOffset = currentBlock.PrevBlock
-		currentBlock, err = readBlockAt(file, int64(currentBlock.PrevBlock))
+		currentBlock, err = s.blockAt(file, int64(currentBlockOffset))
 		if err != nil {
 			return err
 		}
 	}
 	if depth != 0 {
-		log.Println("Broker back path detected at", depth, "depth index")
+		return &CorruptError{Offset: int64(currentBlockOffset), Reason: fmt.Sprint("broken back path at depth ", depth)}
 	}
 	return nil
 }
@@ -252,119 +413,391 @@ func (s *Stack) IterateForward(handler func(depth int, header io.Reader, body io
 	// This operation does not relies on depth counter, so can be used for repare
 	s.guard.Lock()
 	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
 	s.lastAccess = time.Now()
+	return s.iterateForward(handler)
+}
+
+// Iterate forward and repare stack state if all segments iterated. Guard must be locked
+func (s *Stack) iterateForward(handler func(depth int, header io.Reader, body io.Reader) bool) error {
 	file, err := s.getFile()
 	if err != nil {
 		return err
 	}
 	//Get file size
-	fileSize, err := file.Seek(0, os.SEEK_END)
+	fileSize, err := file.Size()
 	if err != nil {
 		return err
 	}
-	_, err = file.Seek(0, os.SEEK_SET)
+	dataFile, err := s.getData()
+	if err != nil {
+		return err
+	}
+	dataSize, err := dataFile.Size()
 	if err != nil {
 		return err
 	}
-	defer file.Seek(0, os.SEEK_END)
 	var (
-		currentBlock       fileBlock // Current block description
-		currentBlockOffset uint64    // Current block offset from begining of file
+		currentBlock       fileBlock    // Current block description
+		currentBlockOffset uint64       // Current block offset from begining of file
+		offsets            []int64      // Offsets of all blocks
+		batch              []fileBlock  // Blocks of unfinished batch
+		report             RepairReport // What was repaired
 	)
+	report.FirstBadOffset = -1
+	logger := s.logger()
 	var depth int
-	for currentBlock.NextBlockPoint() < fileSize {
-		newPos := currentBlock.NextBlockPoint()
-		if newPos > fileSize {
-			log.Println("Bad reference to next block at", currentBlockOffset, "!trunc!")
-			file.Truncate(int64(currentBlockOffset))
-			break
-		}
+	var nextData int64 // Expected data point of next block in split layout
+	newPos := int64(superBlockSize)
+	for newPos < fileSize {
 		block, err := readBlockAt(file, newPos)
 		// Non-full meta-info?
-		if err == io.EOF {
-			log.Println("Broken meta info at", newPos, "!trunc!")
-			file.Truncate(newPos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			logger.Println("Broken meta info at", newPos, "!trunc!")
+			report.bad(newPos)
+			report.truncate(file, fileSize, newPos)
+			fileSize = newPos
 			break
 		}
 		// I/O error
 		if err != nil {
-			log.Println("Can't read block at", newPos)
+			logger.Println("Can't read block at", newPos)
 			return err
 		}
+		// Non-full header or data?
+		if reason := s.checkShape(&block, newPos, nextData, fileSize, dataSize); reason != "" {
+			logger.Println("Bad block at", newPos, ":", reason, "!trunc!")
+			report.bad(newPos)
+			report.truncate(file, fileSize, newPos)
+			fileSize = newPos
+			break
+		}
+		// Segment over limits is not dropped: stack can't be used with the limits
+		if err = s.checkLimits(&block, true); err != nil {
+			return err
+		}
+		// Block without commit marker is not committed
+		if s.checksums() {
+			_, ok, err := s.readTrailer(file, &block)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				logger.Println("No commit marker of block at", newPos, "!trunc!")
+				report.bad(newPos)
+				report.truncate(file, fileSize, newPos)
+				fileSize = newPos
+				break
+			}
+		}
 		// Check back-ref
+		if block.PrevBlock != currentBlockOffset && s.options.readOnly {
+			// Can't be fixed: valid prefix ends here
+			logger.Println("Bad back reference", block.PrevBlock, "!=", currentBlockOffset, "!trunc!")
+			report.bad(newPos)
+			report.truncate(file, fileSize, newPos)
+			fileSize = newPos
+			break
+		}
 		if block.PrevBlock != currentBlockOffset {
-			log.Println("Bad back reference", block.PrevBlock, "!=", currentBlockOffset, "!upd!")
+			logger.Println("Bad back reference", block.PrevBlock, "!=", currentBlockOffset, "!upd!")
+			report.bad(newPos)
+			report.BackRefsFixed++
 			block.PrevBlock = currentBlockOffset
 			block.writeTo(file, newPos)
+			s.sealBlock(&block)
 		}
 		// Update current state
 		currentBlockOffset = uint64(newPos)
 		currentBlock = block
-		body := io.NewSectionReader(file, int64(currentBlock.DataPoint), int64(currentBlock.DataSize))
-		header := io.NewSectionReader(file, int64(currentBlock.HeaderPoint), int64(currentBlock.HeaderSize))
-		// invoke block processor
-		if handler != nil && !handler(depth, header, body) {
-			return nil
+		offsets = append(offsets, newPos)
+		newPos = s.blockEnd(&currentBlock)
+		nextData = int64(block.NextBlockPoint())
+		// Segments of batch are visible only with last block of batch
+		batch = append(batch, block)
+		if block.Batch {
+			continue
+		}
+		// Only the last batch can be torn by crash
+		if newPos == fileSize && !s.verifyBatch(batch, offsets[len(offsets)-len(batch):]) {
+			break
+		}
+		report.BlocksScanned += len(batch)
+		for i := range batch {
+			body := io.NewSectionReader(dataFile, int64(batch[i].DataPoint), int64(batch[i].DataSize))
+			header := io.NewSectionReader(file, int64(batch[i].HeaderPoint), int64(batch[i].HeaderSize))
+			// invoke block processor
+			if handler != nil && !handler(depth, header, body) {
+				return nil
+			}
+			depth++
+		}
+		batch = batch[:0]
+	}
+	// Remove blocks of unfinished batch
+	if len(batch) > 0 {
+		start := offsets[depth]
+		logger.Println("Unfinished batch of", len(batch), "blocks at", start, "!trunc!")
+		report.bad(start)
+		report.truncate(file, fileSize, start)
+		offsets = offsets[:depth]
+		currentBlock, currentBlockOffset = fileBlock{}, 0
+		if depth > 0 {
+			currentBlockOffset = uint64(offsets[depth-1])
+			currentBlock, err = s.blockAt(file, offsets[depth-1])
+			if err != nil {
+				return err
+			}
 		}
-		depth++
 	}
+	// Remove bodies without meta-info
+	if s.split() && currentBlock.NextBlockPoint() < dataSize {
+		logger.Println("Orphan data in blob after", currentBlock.NextBlockPoint(), "!trunc!")
+		report.truncate(dataFile, dataSize, int64(currentBlock.NextBlockPoint()))
+	}
+	s.repair = report
 	s.depth = depth
 	s.currentBlock = currentBlock
 	s.currentBlockPos = int64(currentBlockOffset)
-	return nil
+	s.offsets = offsets
+	s.cacheReset()
+	s.nextSeq = s.super.NextSequence
+	if s.nextSeq < currentBlock.Sequence+1 {
+		s.nextSeq = currentBlock.Sequence + 1
+	}
+	return s.rebuildIndexes()
+}
+
+// Verify checksums of blocks of batch. Guard must be locked
+func (s *Stack) verifyBatch(batch []fileBlock, offsets []int64) bool {
+	for i := range batch {
+		ok, err := s.verifyBlock(&batch[i])
+		if err != nil || !ok {
+			s.logger().Println("Checksum mismatch of block at", offsets[i], "!trunc!", err)
+			return false
+		}
+	}
+	return true
 }
 
-// Repare stack segements
-func (s *Stack) Repare() error { return s.IterateForward(nil) }
+// Repare stack segements. Returns report of what was repaired
+func (s *Stack) Repare() (RepairReport, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return s.repair, err
+	}
+	s.lastAccess = time.Now()
+	err := s.iterateForward(nil)
+	return s.repair, err
+}
 
-// Close backend stack file. If access is requried, file will automatically reopened
+// Close backend stack file. Any access after Close returns ErrClosed unless stack is opened
+// WithReopen: then file will automatically reopened if access is requried. Stack over custom
+// storage can't be reopened
 func (s *Stack) Close() error {
 	s.guard.Lock()
 	defer s.guard.Unlock()
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	err := s.release()
+	s.closed = !s.options.reopen || s.fileName == ""
+	return err
+}
+
+// Save indexes and close storages keeping identity of files. Guard must be locked
+func (s *Stack) release() error {
+	err := s.saveIndexes()
+	s.cacheReset()
+	if s.blob != nil {
+		s.blobInfo = identity(s.blob)
+		if closeErr := s.blob.Close(); closeErr != nil {
+			err = closeErr
+		}
+		s.blob = nil
+	}
+	if s.journal != nil && s.options.journal == nil {
+		s.journal.Close()
+		s.journal = nil
+	}
 	if s.file != nil {
-		err := s.file.Close()
+		s.fileInfo = identity(s.file)
+		if closeErr := s.file.Close(); closeErr != nil {
+			err = closeErr
+		}
 		s.file = nil
-		return err
 	}
-	return nil
+	return err
 }
 
-func (s *Stack) getFile() (*os.File, error) {
+func (s *Stack) getFile() (Storage, error) {
 	if s.file == nil {
-		f, err := os.OpenFile(s.fileName, os.O_CREATE|os.O_RDWR, 0755)
+		storage, err := s.reopen(s.fileName, s.fileInfo, s.fileView)
 		if err != nil {
 			return nil, err
 		}
-		s.file = f
+		s.file = storage
 	}
 	return s.file, nil
 }
 
-// OpenStack - open or create stack
-func OpenStack(filename string) (*Stack, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0755)
+func (s *Stack) getBlob() (Storage, error) {
+	if s.blob == nil {
+		storage, err := s.reopen(s.blobName, s.blobInfo, s.blobView)
+		if err != nil {
+			return nil, err
+		}
+		s.blob = storage
+	}
+	return s.blob, nil
+}
+
+// Open file storage again after Close or Release. File must be same as on release (if info is
+// known): missing, replaced or modified file is not reopened
+func (s *Stack) reopen(fileName string, info os.FileInfo, overlay *journalRegion) (Storage, error) {
+	if s.closed || fileName == "" {
+		return nil, ErrClosed
+	}
+	f, err := openLocked(fileName, openMode(s.options.readOnly)&^os.O_CREATE)
+	if os.IsNotExist(err) {
+		return nil, ErrFileChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info != nil && !sameFile(f, info) {
+		f.Close()
+		return nil, ErrFileChanged
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Reset(s.options.idleTimeout)
+	}
+	return s.protect(s.wrap(NewFileStorage(f)), overlay), nil
+}
+
+// Open and lock file (see lockFile). File opened for writing is locked exclusively and truncated
+// only after lock
+func openLocked(fileName string, flag int) (*os.File, error) {
+	file, err := os.OpenFile(fileName, flag&^os.O_TRUNC, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(file, flag&os.O_RDWR == 0)
+	if err == nil && flag&os.O_TRUNC != 0 {
+		err = file.Truncate(0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Storage with bodies of segments: blob in split layout or same file in interleaved
+func (s *Stack) getData() (Storage, error) {
+	if s.split() {
+		return s.getBlob()
+	}
+	return s.getFile()
+}
+
+// OpenStack - open or create stack. Stack in read-only mode (see WithReadOnly) is not created
+func OpenStack(filename string, options ...Option) (*Stack, error) {
+	file, err := openLocked(filename, openMode(readOnly(options)))
 	if err != nil {
 		return nil, err
 	}
-	return NewStack(file)
+	return NewStack(file, options...)
 }
 
 // CreateStack - create or truncate stack
-func CreateStack(filename string) (*Stack, error) {
-	file, err := os.OpenFile(filename, os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0755)
+func CreateStack(filename string, options ...Option) (*Stack, error) {
+	if readOnly(options) {
+		return nil, ErrReadOnly
+	}
+	file, err := openLocked(filename, os.O_TRUNC|os.O_CREATE|os.O_RDWR)
 	if err != nil {
 		return nil, err
 	}
-	return NewStack(file)
+	return NewStack(file, options...)
 }
 
-// NewStack - create new stack based on file
-func NewStack(file *os.File) (*Stack, error) {
-	stack := &Stack{file: file, fileName: file.Name()}
-	err := stack.Repare()
+// NewStack - create new stack based on file. File in legacy format (without super block)
+// will be upgraded to current format. File is locked while stack uses it: ErrLocked returned if
+// it's already used by another stack (read-only stacks may share file)
+func NewStack(file *os.File, options ...Option) (*Stack, error) {
+	if err := lockFile(file, readOnly(options)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return newStack(NewFileStorage(file), nil, file.Name(), "", options)
+}
+
+// Create stack in interleaved layout (blob is nil) or in split layout. Names of files are
+// used for reopen and sidecar files and are empty for custom storages
+func newStack(file, blob Storage, fileName, blobName string, options []Option) (*Stack, error) {
+	stack := &Stack{fileName: fileName, blobName: blobName}
+	for _, option := range options {
+		option(&stack.options)
+	}
+	if stack.options.cacheSize > 0 {
+		stack.cache = &topCache{maxBytes: stack.options.cacheSize}
+	}
+	stack.file = stack.protect(stack.wrap(file), nil)
+	if blob != nil {
+		stack.blob = stack.protect(stack.wrap(blob), nil)
+	}
+	var flags uint64
+	if blob != nil {
+		flags |= flagSplit
+	}
+	sb, legacy, err := initFormat(file, flags|flagChecksum, !stack.options.readOnly)
+	if err == nil && !legacy && sb.Flags&flagSplit != flags {
+		err = ErrLayout
+	}
+	if err == nil && legacy && (blob != nil || fileName == "") {
+		// Only single file can be upgraded
+		err = ErrUnsupportedFormat
+	}
+	if err == nil && legacy && stack.options.readOnly {
+		err = ErrLegacyFormat
+	}
 	if err != nil {
 		stack.Close()
 		return nil, err
 	}
+	if legacy {
+		// Original file is kept locked till upgraded file replaces it
+		var upgraded *os.File
+		sb, upgraded, err = upgradeLegacy(stack.file, stack.fileName)
+		stack.file.Close()
+		stack.file = nil
+		if err != nil {
+			return nil, err
+		}
+		stack.file = stack.wrap(NewFileStorage(upgraded))
+	}
+	stack.super = sb
+	// Roll back interrupted transaction
+	var rolledBack bool
+	if stack.options.readOnly {
+		rolledBack, err = stack.viewJournal()
+	} else {
+		rolledBack, err = stack.rollbackJournal()
+	}
+	if err == nil {
+		_, err = stack.Repare()
+		stack.repair.RolledBack = rolledBack
+	}
+	if err != nil {
+		stack.Close()
+		return nil, err
+	}
+	if stack.options.idleTimeout > 0 && fileName != "" {
+		stack.idleTimer = time.AfterFunc(stack.options.idleTimeout, stack.releaseIdle)
+	}
 	return stack, nil
 }
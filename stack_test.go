@@ -26,6 +26,7 @@ func TestStackSimple(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer stack.Close()
 	if stack.Depth() != 1 {
 		t.Fatal("Corrupted no-empty stack")
 	}
@@ -59,12 +60,12 @@ func TestStackMultiPush(t *testing.T) {
 		t.Fatal(err)
 	}
 	for i := 0; i < N; i++ {
-		depth, err := stack.Push([]byte(header), []byte(data))
+		seq, err := stack.Push([]byte(header), []byte(data))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if i+1 != depth {
-			t.Fatal("Expected push returns depth")
+		if uint64(i+1) != seq {
+			t.Fatal("Expected push returns sequence number")
 		}
 	}
 	if stack.Depth() != N {
@@ -84,12 +85,12 @@ func TestStackIterate(t *testing.T) {
 	for i := 0; i < N; i++ {
 		head := fmt.Sprintf("%v-%v", header, i)
 		body := fmt.Sprintf("%v-%v", data, i)
-		depth, err := stack.Push([]byte(head), []byte(body))
+		seq, err := stack.Push([]byte(head), []byte(body))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if i+1 != depth {
-			t.Fatal("Expected push returns depth")
+		if uint64(i+1) != seq {
+			t.Fatal("Expected push returns sequence number")
 		}
 	}
 	if stack.Depth() != N {
@@ -132,12 +133,12 @@ func TestStackBackIterate(t *testing.T) {
 	for i := 0; i < N; i++ {
 		head := fmt.Sprintf("%v-%v", header, i)
 		body := fmt.Sprintf("%v-%v", data, i)
-		depth, err := stack.Push([]byte(head), []byte(body))
+		seq, err := stack.Push([]byte(head), []byte(body))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if i+1 != depth {
-			t.Fatal("Expected push returns depth")
+		if uint64(i+1) != seq {
+			t.Fatal("Expected push returns sequence number")
 		}
 	}
 	if stack.Depth() != N {
@@ -213,12 +214,12 @@ func BenchmarkStackMultiPush(b *testing.B) {
 	defer stack.Close()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		depth, err := stack.Push([]byte(header), []byte(data))
+		seq, err := stack.Push([]byte(header), []byte(data))
 		if err != nil {
 			b.Fatal(err)
 		}
-		if i+1 != depth {
-			b.Fatal("Expected push returns depth")
+		if uint64(i+1) != seq {
+			b.Fatal("Expected push returns sequence number")
 		}
 	}
 }
@@ -259,12 +260,12 @@ func BenchmarkStackMultiPeak(b *testing.B) {
 	data := "AAABBBCCC"
 	header := "112233"
 	defer stack.Close()
-	depth, err := stack.Push([]byte(header), []byte(data))
+	seq, err := stack.Push([]byte(header), []byte(data))
 	if err != nil {
 		b.Fatal(err)
 	}
-	if 1 != depth {
-		b.Fatal("Expected push returns depth")
+	if 1 != seq {
+		b.Fatal("Expected push returns sequence number")
 	}
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
@@ -295,12 +296,12 @@ func BenchmarkStackMultiPushPop(b *testing.B) {
 	defer stack.Close()
 	b.ResetTimer()
 	for i := b.N - 1; i >= 0; i-- {
-		depth, err := stack.Push([]byte(header), []byte(data))
+		_, err := stack.Push([]byte(header), []byte(data))
 		if err != nil {
 			b.Fatal(err)
 		}
-		if depth != 1 {
-			b.Fatal("Expected push returns depth")
+		if stack.Depth() != 1 {
+			b.Fatal("Expected push increments depth")
 		}
 		h, d, err := stack.Pop()
 		if err != nil {
@@ -0,0 +1,129 @@
+package fstack
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Storage - random access backend of stack: file, memory or something custom
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	// Truncate changes size of storage
+	Truncate(size int64) error
+	// Size of storage in bytes
+	Size() (int64, error)
+	// Sync commits written data to durable media
+	Sync() error
+	// Close releases resources of storage
+	Close() error
+}
+
+// File as storage
+type fileStorage struct {
+	*os.File
+}
+
+// NewFileStorage - file as storage of stack
+func NewFileStorage(file *os.File) Storage { return &fileStorage{file} }
+
+// Size of file
+func (fs *fileStorage) Size() (int64, error) {
+	stat, err := fs.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// MemoryStorage - storage in memory (like tmpfs). Content is kept after Close
+type MemoryStorage struct {
+	guard sync.RWMutex
+	data  []byte
+}
+
+// NewMemoryStorage - create new empty storage in memory
+func NewMemoryStorage() *MemoryStorage { return &MemoryStorage{} }
+
+// ReadAt reads len(p) bytes from offset
+func (ms *MemoryStorage) ReadAt(p []byte, offset int64) (int, error) {
+	ms.guard.RLock()
+	defer ms.guard.RUnlock()
+	if offset >= int64(len(ms.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, ms.data[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes p at offset and grows storage if required
+func (ms *MemoryStorage) WriteAt(p []byte, offset int64) (int, error) {
+	ms.guard.Lock()
+	defer ms.guard.Unlock()
+	if end := offset + int64(len(p)); end > int64(len(ms.data)) {
+		ms.resize(end)
+	}
+	return copy(ms.data[offset:], p), nil
+}
+
+// Truncate changes size of storage. New space is filled by zeros
+func (ms *MemoryStorage) Truncate(size int64) error {
+	ms.guard.Lock()
+	defer ms.guard.Unlock()
+	ms.resize(size)
+	return nil
+}
+
+// Size of content
+func (ms *MemoryStorage) Size() (int64, error) {
+	ms.guard.RLock()
+	defer ms.guard.RUnlock()
+	return int64(len(ms.data)), nil
+}
+
+// Sync does nothing
+func (ms *MemoryStorage) Sync() error { return nil }
+
+// Close does nothing: content is kept
+func (ms *MemoryStorage) Close() error { return nil }
+
+// Bytes - copy of content
+func (ms *MemoryStorage) Bytes() []byte {
+	ms.guard.RLock()
+	defer ms.guard.RUnlock()
+	return append([]byte(nil), ms.data...)
+}
+
+func (ms *MemoryStorage) resize(size int64) {
+	if size <= int64(cap(ms.data)) {
+		old := len(ms.data)
+		ms.data = ms.data[:size]
+		for i := old; i < len(ms.data); i++ {
+			ms.data[i] = 0
+		}
+		return
+	}
+	data := make([]byte, size, size+size/4)
+	copy(data, ms.data)
+	ms.data = data
+}
+
+// NewStorageStack - create new stack based on custom storage
+func NewStorageStack(storage Storage, options ...Option) (*Stack, error) {
+	return newStack(storage, nil, "", "", options)
+}
+
+// NewSplitStorageStack - create new stack in split layout based on custom storages for
+// meta-info with headers and for bodies
+func NewSplitStorageStack(storage, blob Storage, options ...Option) (*Stack, error) {
+	return newStack(storage, blob, "", "", options)
+}
+
+// NewMemoryStack - create new empty stack in memory
+func NewMemoryStack(options ...Option) (*Stack, error) {
+	return NewStorageStack(NewMemoryStorage(), options...)
+}
@@ -0,0 +1,101 @@
+package fstack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	if _, err := storage.WriteAt([]byte("world"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.WriteAt([]byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.Bytes(), []byte("hello\x00world")) {
+		t.Fatalf("Unexpected content %q", storage.Bytes())
+	}
+	buf := make([]byte, 8)
+	n, err := storage.ReadAt(buf, 6)
+	if n != 5 || err != io.EOF {
+		t.Fatal("Expected short read with EOF, got", n, err)
+	}
+	if err = storage.Truncate(3); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(storage.Bytes(), []byte("hel\x00\x00")) {
+		t.Fatalf("Truncate must zero new space, got %q", storage.Bytes())
+	}
+	if size, _ := storage.Size(); size != 5 {
+		t.Fatal("Unexpected size", size)
+	}
+}
+
+func TestStorageStack(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := stack.Push([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = stack.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = stack.Peak(); err != ErrClosed {
+		t.Fatal("Expected closed stack, got", err)
+	}
+	// Same storage keeps content
+	stack, err = NewStorageStack(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stack.Depth() != 9 {
+		t.Fatal("Unexpected depth", stack.Depth())
+	}
+	header, data, err := stack.Peak()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "header-8" || string(data) != "body-8" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	if _, err = NewSplitStorageStack(storage, NewMemoryStorage()); err != ErrLayout {
+		t.Fatal("Interleaved storage must not be opened as split, got", err)
+	}
+}
+
+func TestSplitStorageStack(t *testing.T) {
+	meta, blob := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewSplitStorageStack(meta, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte("header"), []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob.Bytes(), []byte("body")) {
+		t.Fatalf("Body must be in blob storage, got %q", blob.Bytes())
+	}
+	// Orphan body must be removed on repare
+	blob.WriteAt([]byte("orphan"), 4)
+	stack, err = NewSplitStorageStack(meta, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob.Bytes(), []byte("body")) {
+		t.Fatalf("Orphan body must be truncated, got %q", blob.Bytes())
+	}
+}
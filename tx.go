@@ -0,0 +1,147 @@
+package fstack
+
+import "time"
+
+// Tx - transaction over stack: pops and pushes are applied by Commit as one unit. Stack is locked
+// from Begin till Commit or Rollback, so methods of stack must not be called inside transaction.
+// Pop-only transaction is applied by one truncate, push-only - by one batch (see PushBatch).
+// Transaction with both pops and pushes saves overwritten tail to undo journal, so interrupted
+// commit is rolled back on next open
+type Tx struct {
+	stack   *Stack
+	popped  []fileBlock // Popped blocks of stack from top to bottom
+	headers [][]byte    // Headers of popped blocks
+	pushes  []Message
+	done    bool
+}
+
+// Begin - start transaction. Stack is locked till Commit or Rollback
+func (s *Stack) Begin() *Tx {
+	s.guard.Lock()
+	s.lastAccess = time.Now()
+	return &Tx{stack: s}
+}
+
+// Depth of stack as seen inside transaction
+func (tx *Tx) Depth() int { return tx.stack.depth - len(tx.popped) + len(tx.pushes) }
+
+// Push segment in transaction. Header and data must not be modified till Commit
+func (tx *Tx) Push(header, data []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.pushes = append(tx.pushes, Message{Header: header, Data: data})
+	return nil
+}
+
+// Pop segment in transaction: segment pushed in the transaction or segment of stack.
+// Returns ErrEmpty if depth is 0
+func (tx *Tx) Pop() (header, data []byte, err error) {
+	if tx.done {
+		return nil, nil, ErrTxDone
+	}
+	if n := len(tx.pushes); n > 0 {
+		msg := tx.pushes[n-1]
+		tx.pushes = tx.pushes[:n-1]
+		return msg.Header, msg.Data, nil
+	}
+	s := tx.stack
+	index := s.depth - 1 - len(tx.popped)
+	if index < 0 {
+		return nil, nil, ErrEmpty
+	}
+	block := s.currentBlock
+	if index != s.depth-1 {
+		file, err := s.getFile()
+		if err != nil {
+			return nil, nil, err
+		}
+		block, err = s.blockAt(file, s.offsets[index])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	header, data, err = s.readBlock(&block)
+	if err != nil {
+		return nil, nil, err
+	}
+	tx.popped = append(tx.popped, block)
+	tx.headers = append(tx.headers, header)
+	return header, data, nil
+}
+
+// Commit - apply transaction and unlock stack. Stack is not changed if error returned
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	s := tx.stack
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return err
+	}
+	if len(tx.pushes) == 0 {
+		return s.apply(tx.popped, tx.headers, nil, 0, 0, false)
+	}
+	return s.apply(tx.popped, tx.headers, tx.pushes, s.pushTimestamp(), s.nextSeq, s.options.sync)
+}
+
+// Rollback - discard transaction and unlock stack
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	tx.stack.guard.Unlock()
+	return nil
+}
+
+// Apply changes: remove top blocks (from top to bottom, with headers for indexes) and push
+// messages with timestamp and sequence numbers from seq. Guard must be locked
+func (s *Stack) apply(popped []fileBlock, headers [][]byte, pushes []Message, timestamp int64, seq uint64, sync bool) error {
+	if s.options.readOnly {
+		return ErrReadOnly
+	}
+	if err := s.checkPush(pushes); err != nil {
+		return err
+	}
+	if len(popped) == 0 {
+		if len(pushes) == 0 {
+			return nil
+		}
+		return s.pushBatch(pushes, timestamp, seq, sync)
+	}
+	if len(pushes) == 0 {
+		return s.removeTopN(popped, headers)
+	}
+	// New blocks overwrite removed ones: keep tail (with meta-info of new top) in journal
+	start := s.offsets[len(s.offsets)-len(popped)]
+	if len(s.offsets) > len(popped) {
+		start = s.offsets[len(s.offsets)-len(popped)-1]
+	}
+	dataOffset, dataEnd := s.blobTail(int64(popped[len(popped)-1].DataPoint))
+	err := s.beginJournal(start, toEnd, dataOffset, dataEnd, seq+uint64(len(pushes))-1)
+	if err != nil {
+		return err
+	}
+	err = s.removeTopN(popped, headers)
+	if err == nil {
+		err = s.pushBatch(pushes, timestamp, seq, false)
+	}
+	if err != nil {
+		return s.rollback(err)
+	}
+	return s.endJournal()
+}
+
+// Restore state from journal after failed operation and return error of operation. Guard must
+// be locked
+func (s *Stack) rollback(err error) error {
+	if _, rollbackErr := s.rollbackJournal(); rollbackErr != nil {
+		s.logger().Println("Can't rollback transaction:", rollbackErr)
+	} else if repareErr := s.iterateForward(nil); repareErr != nil {
+		s.logger().Println("Can't repare stack after rollback:", repareErr)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package fstack
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTxPopPush(t *testing.T) {
+	stack, err := CreateStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.PushBatch(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	tx := stack.Begin()
+	header, data, err := tx.Pop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != "header-2" || string(data) != "body-2" {
+		t.Fatal("Unexpected segment", string(header), string(data))
+	}
+	if _, _, err = tx.Pop(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Push([]byte("header-1"), []byte("body-1"))
+	tx.Push([]byte("header-2"), []byte("body-2"))
+	tx.Push([]byte("header-3"), []byte("body-3"))
+	if tx.Depth() != 4 {
+		t.Fatal("Unexpected depth in transaction", tx.Depth())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != ErrTxDone {
+		t.Fatal("Second commit must fail, got", err)
+	}
+	if _, err = os.Stat("temp.stack.journal"); !os.IsNotExist(err) {
+		t.Fatal("Journal must be removed after commit")
+	}
+	stack.Close()
+	stack, err = OpenStack("temp.stack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	checkBatchStack(t, stack, 4)
+}
+
+func TestTxRollback(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(2)); err != nil {
+		t.Fatal(err)
+	}
+	tx := stack.Begin()
+	tx.Pop()
+	tx.Pop()
+	if header, _, err := tx.Pop(); err != ErrEmpty || header != nil || tx.Depth() != 0 {
+		t.Fatal("Transaction must see empty stack")
+	}
+	tx.Push([]byte("header"), []byte("body"))
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Push([]byte("header"), []byte("body")); err != ErrTxDone {
+		t.Fatal("Push after rollback must fail, got", err)
+	}
+	checkBatchStack(t, stack, 2)
+}
+
+func TestTxPushPop(t *testing.T) {
+	stack, err := NewMemoryStack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := stack.Begin()
+	tx.Push([]byte("header-0"), []byte("body-0"))
+	tx.Push([]byte("temp"), []byte("temp"))
+	if header, _, _ := tx.Pop(); string(header) != "temp" {
+		t.Fatal("Pushed in transaction segment must be popped first", string(header))
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 1)
+}
+
+func TestTxInterrupted(t *testing.T) {
+	storage, journal := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewStorageStack(storage, WithJournal(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	// Crash in the middle of commit: top removed, but new segments are not written
+	stack.guard.Lock()
+	popped := []fileBlock{stack.currentBlock}
+	if err = stack.beginJournal(stack.offsets[1], toEnd, 0, 0, stack.nextSeq); err != nil {
+		t.Fatal(err)
+	}
+	if err = stack.removeTopN(popped, [][]byte{[]byte("header-2")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.WriteAt([]byte("garbage"), stack.nextBlockPoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack.guard.Unlock()
+	for i := 0; i < 2; i++ {
+		stack, err = NewStorageStack(storage, WithJournal(journal))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size, _ := journal.Size(); size != 0 {
+			t.Fatal("Journal must be cleared after rollback")
+		}
+	}
+	checkBatchStack(t, stack, 3)
+}
+
+func TestSplitTxPopPush(t *testing.T) {
+	stack, err := NewSplitStorageStack(NewMemoryStorage(), NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if _, err = stack.PushBatch(testBatch(3)); err != nil {
+		t.Fatal(err)
+	}
+	tx := stack.Begin()
+	for i := 0; i < 3; i++ {
+		tx.Pop()
+	}
+	for i := 0; i < 5; i++ {
+		tx.Push([]byte(fmt.Sprint("header-", i)), []byte(fmt.Sprint("body-", i)))
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Repare(); err != nil {
+		t.Fatal(err)
+	}
+	checkBatchStack(t, stack, 5)
+}
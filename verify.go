@@ -0,0 +1,199 @@
+package fstack
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Kinds of problems found by Verify
+const (
+	ProblemMeta     = "meta"           // Meta-info of block can't be read
+	ProblemSize     = "size"           // Block is out of file or blob or overlaps other blocks
+	ProblemBackRef  = "back-reference" // Back reference doesn't point to previous block
+	ProblemMarker   = "commit-marker"  // Block has no commit marker
+	ProblemChecksum = "checksum"       // Content of block doesn't match checksum
+	ProblemHeader   = "header"         // Header can't be decoded by ParseHeader
+	ProblemBatch    = "batch"          // Last batch is not finished
+	ProblemOrphan   = "orphan"         // Bodies in blob without meta-info
+	ProblemJournal  = "journal"        // Interrupted operation is not rolled back
+)
+
+// VerifyOptions - checks of Verify. Structure of blocks is always checked
+type VerifyOptions struct {
+	Checksums bool // Verify checksums of all blocks (on open only the last batch is verified)
+	Headers   bool // Check that headers can be decoded by ParseHeader
+	Repair    bool // Repair stack after check. Blocks after first block with wrong checksum are dropped
+}
+
+// VerifyProblem - problem found by Verify
+type VerifyProblem struct {
+	Kind    string `json:"kind"`
+	Offset  int64  `json:"offset"` // Offset of block in file (in blob for orphan bodies)
+	Depth   int    `json:"depth"`  // Number of blocks before block
+	Message string `json:"message"`
+}
+
+// VerifyReport - result of Verify
+type VerifyReport struct {
+	Blocks    int             `json:"blocks"`     // Number of checked blocks
+	FileSize  int64           `json:"file_size"`  // Size of file with meta-info
+	BlobSize  int64           `json:"blob_size"`  // Size of blob in split layout
+	ValidSize int64           `json:"valid_size"` // Size of file before first problem of structure
+	Problems  []VerifyProblem `json:"problems"`
+	Repairs   []RepairReport  `json:"repairs,omitempty"` // Done repairs
+}
+
+// OK - no problems found
+func (vr *VerifyReport) OK() bool { return len(vr.Problems) == 0 }
+
+func (vr *VerifyReport) problem(kind string, offset int64, depth int, format string, args ...interface{}) {
+	vr.Problems = append(vr.Problems, VerifyProblem{
+		Kind:    kind,
+		Offset:  offset,
+		Depth:   depth,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Verify - check integrity of all blocks in file: chain of blocks, back references, sizes against
+// length of files and optionally checksums and headers. Stack is not changed unless opts.Repair is
+// set (it's not allowed in read-only mode). Stack opened in read-only mode is checked as is, so
+// damage which is repaired on usual open is reported
+func (s *Stack) Verify(opts VerifyOptions) (VerifyReport, error) {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	if err := s.checkOpen(); err != nil {
+		return VerifyReport{Problems: []VerifyProblem{}}, err
+	}
+	s.lastAccess = time.Now()
+	if opts.Repair && s.options.readOnly {
+		return VerifyReport{Problems: []VerifyProblem{}}, ErrReadOnly
+	}
+	report, cut, err := s.verify(opts)
+	if err != nil || !opts.Repair || report.OK() {
+		return report, err
+	}
+	if cut > 0 {
+		if err = s.cutAt(cut); err != nil {
+			return report, err
+		}
+	}
+	err = s.iterateForward(nil)
+	report.Repairs = append(report.Repairs, s.repair)
+	return report, err
+}
+
+// Check all blocks. Returns report and offset of first block with wrong checksum (0 if none).
+// Guard must be locked
+func (s *Stack) verify(opts VerifyOptions) (report VerifyReport, cut int64, err error) {
+	report.Problems = []VerifyProblem{}
+	file, err := s.getFile()
+	if err != nil {
+		return report, 0, err
+	}
+	dataFile, err := s.getData()
+	if err != nil {
+		return report, 0, err
+	}
+	if report.FileSize, err = file.Size(); err != nil {
+		return report, 0, err
+	}
+	dataSize := report.FileSize
+	if s.split() {
+		if dataSize, err = dataFile.Size(); err != nil {
+			return report, 0, err
+		}
+		report.BlobSize = dataSize
+	}
+	if s.fileView != nil {
+		report.problem(ProblemJournal, s.fileView.offset, -1, "interrupted operation is rolled back only in view")
+	}
+	var (
+		pos          = int64(superBlockSize)
+		prev         int64       // Offset of previous block
+		nextData     int64       // Expected data point of next block in split layout
+		batchStart   = int64(-1) // Offset of first block of unfinished batch
+		header, data []byte
+	)
+	for ; pos < report.FileSize; report.Blocks++ {
+		block, err := readBlockAt(file, pos)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			report.problem(ProblemMeta, pos, report.Blocks, "broken meta info")
+			break
+		}
+		if err != nil {
+			return report, cut, err
+		}
+		if reason := s.checkShape(&block, pos, nextData, report.FileSize, dataSize); reason != "" {
+			report.problem(ProblemSize, pos, report.Blocks, "%v", reason)
+			break
+		}
+		end := s.blockEnd(&block)
+		if int64(block.PrevBlock) != prev {
+			report.problem(ProblemBackRef, pos, report.Blocks, "back reference %v, expected %v", block.PrevBlock, prev)
+		}
+		if s.checksums() {
+			crc, ok, err := s.readTrailer(file, &block)
+			if err != nil {
+				return report, cut, err
+			}
+			if !ok {
+				report.problem(ProblemMarker, pos, report.Blocks, "no commit marker")
+				break
+			}
+			if opts.Checksums || opts.Headers {
+				header, data, err = s.readBlockInto(&block, header, data)
+				if err != nil {
+					return report, cut, err
+				}
+			}
+			if opts.Checksums && blockChecksum(&block, header, data) != crc {
+				report.problem(ProblemChecksum, pos, report.Blocks, "checksum mismatch")
+				if cut == 0 {
+					cut = pos
+				}
+			}
+		} else if opts.Headers {
+			header, data, err = s.readBlockInto(&block, header, data)
+			if err != nil {
+				return report, cut, err
+			}
+		}
+		if opts.Headers {
+			if _, err := ParseHeader(header); err != nil {
+				report.problem(ProblemHeader, pos, report.Blocks, "%v", err)
+			}
+		}
+		if block.Batch && batchStart < 0 {
+			batchStart = pos
+		} else if !block.Batch {
+			batchStart = -1
+		}
+		prev, pos, nextData = pos, end, int64(block.NextBlockPoint())
+	}
+	report.ValidSize = pos
+	if pos == report.FileSize && batchStart >= 0 {
+		report.problem(ProblemBatch, batchStart, -1, "last batch is not finished")
+	}
+	if s.split() && pos == report.FileSize && nextData < dataSize {
+		report.problem(ProblemOrphan, nextData, -1, "%v bytes of bodies without meta info", dataSize-nextData)
+	}
+	return report, cut, nil
+}
+
+// Drop blocks starting at offset keeping high-water mark of sequence numbers. Guard must be locked
+func (s *Stack) cutAt(offset int64) error {
+	file, err := s.getFile()
+	if err != nil {
+		return err
+	}
+	s.logger().Println("Checksum mismatch of block at", offset, "!trunc!")
+	if s.super.NextSequence < s.nextSeq {
+		s.super.NextSequence = s.nextSeq
+		if err = s.super.writeTo(file); err != nil {
+			return err
+		}
+	}
+	return file.Truncate(offset)
+}
@@ -0,0 +1,103 @@
+package fstack
+
+import "testing"
+
+func TestStackVerify(t *testing.T) {
+	storage := NewMemoryStorage()
+	stack, err := NewStorageStack(storage, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range testBatch(3) {
+		if _, err = stack.Push(message.Header, message.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err := stack.Verify(VerifyOptions{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Blocks != 3 || report.ValidSize != report.FileSize {
+		t.Fatal("Unexpected report", report)
+	}
+	// Damage data of block in the middle: it's not checked on open
+	middle := stack.offsets[1]
+	block, err := readBlockAt(storage, middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = storage.WriteAt([]byte("X"), int64(block.DataPoint)); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewStorageStack(storage, WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err = stack.Verify(VerifyOptions{}); err != nil || !report.OK() {
+		t.Fatal("Unexpected report without checksums", report, err)
+	}
+	report, err = stack.Verify(VerifyOptions{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Kind != ProblemChecksum || report.Problems[0].Offset != middle ||
+		report.Problems[0].Depth != 1 || stack.Depth() != 3 {
+		t.Fatal("Unexpected report", report)
+	}
+	report, err = stack.Verify(VerifyOptions{Checksums: true, Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || len(report.Repairs) != 1 || report.Repairs[0].BlocksScanned != 1 {
+		t.Fatal("Unexpected report", report)
+	}
+	if report, err = stack.Verify(VerifyOptions{Checksums: true}); err != nil || !report.OK() {
+		t.Fatal("Unexpected report after repair", report, err)
+	}
+	// Sequence numbers of dropped segments are not reused
+	if seq, err := stack.Push([]byte("header-1"), []byte("body-1")); err != nil || seq != 4 {
+		t.Fatal(seq, err)
+	}
+	checkBatchStack(t, stack, 2)
+}
+
+func TestStackVerifyReadOnly(t *testing.T) {
+	storage, blob := NewMemoryStorage(), NewMemoryStorage()
+	stack, err := NewSplitStorageStack(storage, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.Push([]byte(`{"kind":"a"}`), []byte("body-0")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stack.PushBatch([]Message{{[]byte("not json"), []byte("body-1")}, {[]byte(`{}`), []byte("body-2")}}); err != nil {
+		t.Fatal(err)
+	}
+	// Last block of batch is lost, body is written
+	if err = storage.Truncate(stack.currentBlockPos); err != nil {
+		t.Fatal(err)
+	}
+	stack, err = NewSplitStorageStack(storage, blob, WithReadOnly(), WithLogger(&testLogger{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := stack.Verify(VerifyOptions{Checksums: true, Headers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []string{ProblemHeader, ProblemBatch, ProblemOrphan}
+	if len(report.Problems) != len(kinds) || report.Blocks != 2 {
+		t.Fatal("Unexpected report", report)
+	}
+	for i, kind := range kinds {
+		if report.Problems[i].Kind != kind {
+			t.Fatal("Unexpected problem", report.Problems[i], "expected", kind)
+		}
+	}
+	if _, err = stack.Verify(VerifyOptions{Repair: true}); err != ErrReadOnly {
+		t.Fatal(err)
+	}
+	if stack.Depth() != 1 {
+		t.Fatal("Unexpected depth", stack.Depth())
+	}
+}